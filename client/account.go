@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/client/internal/utils"
+	"gopkg.in/ini.v1"
+)
+
+var ConfigPath = ""
+
+var Login = false
+var Register = false
+var Logout = false
+
+// The session token handed out by the server when logging in, empty if playing as a guest
+var Token = ""
+
+// The account that the token belongs to
+var AccountUsername = ""
+
+func LoadAccountConfig() {
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(fmt.Errorf("os getwd: %w", err))
+	}
+	ConfigPath = filepath.Join(wd, "config.ini")
+
+	exists, err := utils.FileExists(ConfigPath)
+	if err != nil {
+		panic(fmt.Errorf("error with os: %w", err))
+	}
+	if !exists {
+		return
+	}
+
+	data, err := ini.Load(ConfigPath)
+	if err != nil {
+		panic(fmt.Errorf("ini: %w", err))
+	}
+	section := data.Section("Account")
+
+	AccountUsername = section.Key("Username").String()
+	Token = section.Key("Token").String()
+}
+
+func SaveAccountConfig() {
+	data := ini.Empty()
+	if exists, _ := utils.FileExists(ConfigPath); exists {
+		f, err := ini.Load(ConfigPath)
+		if err != nil {
+			panic(fmt.Errorf("ini: %w", err))
+		}
+		data = f
+	}
+
+	section := data.Section("Account")
+	section.Key("Username").SetValue(AccountUsername)
+	section.Key("Token").SetValue(Token)
+
+	if err := data.SaveTo(ConfigPath); err != nil {
+		panic(fmt.Errorf("ini save: %w", err))
+	}
+}
+
+// Sends the form to one of the server's /auth endpoints, returning the server's error message if it fails
+func postAuth(path string, form url.Values, v any) error {
+	p, err := url.JoinPath(Server, path)
+	if err != nil {
+		panic(fmt.Errorf("join: %w", err))
+	}
+
+	res, err := http.PostForm(p, form)
+	if err != nil {
+		return fmt.Errorf("could not reach the server: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil || apiErr.Message == "" {
+			return fmt.Errorf("server responded with %s", res.Status)
+		}
+		return errors.New(apiErr.Message)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+	return nil
+}
+
+func RegisterAccount(username string, password string) error {
+	return postAuth("/auth/register", url.Values{"username": {username}, "password": {password}}, nil)
+}
+
+func LoginAccount(username string, password string) error {
+	var data struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+	}
+	if err := postAuth("/auth/login", url.Values{"username": {username}, "password": {password}}, &data); err != nil {
+		return err
+	}
+
+	Token = data.Token
+	AccountUsername = data.Username
+	return nil
+}
+
+func LogoutAccount() {
+	if Token != "" {
+		p, err := url.JoinPath(Server, "/auth/logout")
+		if err != nil {
+			panic(fmt.Errorf("join: %w", err))
+		}
+
+		req, err := http.NewRequest(http.MethodPost, p, nil)
+		if err != nil {
+			panic(fmt.Errorf("http request: %w", err))
+		}
+		req.Header.Set("Authorization", "Bearer "+Token)
+
+		// Forgetting the token is enough, even if the server can't be reached
+		if res, err := http.DefaultClient.Do(req); err == nil {
+			res.Body.Close()
+		}
+	}
+
+	Token = ""
+	AccountUsername = ""
+}
+
+// Handles the login flags, and picks the username to play as
+func SetupAccount() {
+	LoadAccountConfig()
+
+	if Logout {
+		LogoutAccount()
+		SaveAccountConfig()
+		fmt.Println("[Account] Logged out!")
+	}
+
+	if Register || Login {
+		for strings.TrimSpace(Username) == "" {
+			Username = strings.TrimSpace(utils.GetTextInput("Insert your username", 16))
+		}
+		password := utils.GetPasswordInput("Insert your password", 72)
+
+		if Register {
+			if err := RegisterAccount(Username, password); err != nil {
+				fmt.Printf("[Account] Could not register: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("[Account] Registered!")
+		}
+
+		if err := LoginAccount(Username, password); err != nil {
+			fmt.Printf("[Account] Could not log in: %s\n", err)
+			os.Exit(1)
+		}
+		SaveAccountConfig()
+		fmt.Printf("[Account] Logged in as %s!\n", AccountUsername)
+	}
+
+	if Token == "" {
+		return
+	}
+
+	// A different username was asked for, so play as a guest instead
+	if Username != "" && !strings.EqualFold(Username, AccountUsername) {
+		Token = ""
+		return
+	}
+	Username = AccountUsername
+}
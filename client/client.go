@@ -13,8 +13,8 @@ import (
 	"strings"
 	"time"
 
-	"git.jaezmien.com/Jaezmien/notitg-party/client/events"
 	"git.jaezmien.com/Jaezmien/notitg-party/client/internal/utils"
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
 	lemonade "github.com/Jaezmien/notitg-lemonade-go"
 	bolt "go.etcd.io/bbolt"
 )
@@ -57,6 +57,9 @@ func init() {
 
 	flag.StringVar(&Server, "server", "http://localhost:8080", "The server to connect to")
 	flag.StringVar(&Username, "username", "", "Your username")
+	flag.BoolVar(&Register, "register", false, "Register an account on the server, then log in")
+	flag.BoolVar(&Login, "login", false, "Log in to your account on the server, saving the login to config.ini")
+	flag.BoolVar(&Logout, "logout", false, "Forget the login saved in config.ini")
 
 	flag.Parse()
 
@@ -162,6 +165,8 @@ func init() {
 }
 
 func main() {
+	SetupAccount()
+
 	for strings.TrimSpace(Username) == "" {
 		Username = strings.TrimSpace(utils.GetTextInput("Insert your username", 16))
 	}
@@ -202,6 +207,41 @@ func main() {
 		}
 	}
 
+	// Reads the song that NotITG has picked, and looks up its hash.
+	// Songs that haven't been scanned are only logged, it's up to the caller to decide what to do with them.
+	readSong := func(buffer []int32) (protocol.SetSong, bool) {
+		message, err := lemonade.DecodeBufferToString(buffer)
+		if err != nil {
+			panic(fmt.Errorf("decode: %w", err))
+		}
+
+		// Attempt to read json data
+		var songData struct {
+			Key        string `json:"key"`
+			Difficulty string `json:"difficulty"`
+		}
+
+		if err := json.Unmarshal([]byte(message), &songData); err != nil {
+			instance.Logger.Debug("error while parsing client message", "error", err)
+			instance.AttemptClose()
+			return protocol.SetSong{}, false
+		}
+
+		// Get hash of song
+		if !HasSongKey(db, songData.Key) {
+			instance.Logger.Info(fmt.Sprintf("client has no hash of this song! (%s)\n", songData.Key))
+			instance.Logger.Info("run this program again with -scan")
+			return protocol.SetSong{}, false
+		}
+		hash, has := GetSongHash(db, songData.Key)
+		if !has {
+			instance.Logger.Info(fmt.Sprintf("could not find song with key: %s\n", songData.Key))
+			return protocol.SetSong{}, false
+		}
+
+		return protocol.SetSong{Hash: hash, Difficulty: songData.Difficulty}, true
+	}
+
 	instance.Lemon.OnBufferRead = func(l *lemonade.Lemonade, buffer []int32) {
 		instance.Logger.Debug("received buffer", slog.String("buffer", fmt.Sprintf("%v", buffer)))
 
@@ -247,8 +287,27 @@ func main() {
 				if instance.IsInRoom() {
 					return
 				}
-				id := instance.CreateRoom()
-				instance.JoinRoom(id)
+
+				// The following buffer content, if any, is the room's options in json
+				var roomData struct {
+					Password   string `json:"password"`
+					Private    bool   `json:"private"`
+					MaxPlayers int    `json:"max_players"`
+				}
+				if len(buffer) > 2 {
+					message, err := lemonade.DecodeBufferToString(buffer[2:])
+					if err != nil {
+						panic(fmt.Errorf("decode: %w", err))
+					}
+
+					if err := json.Unmarshal([]byte(message), &roomData); err != nil {
+						instance.Logger.Debug("error while parsing client message", "error", err)
+						return
+					}
+				}
+
+				id := instance.CreateRoom(roomData.Password, roomData.Private, roomData.MaxPlayers)
+				instance.JoinRoom(id, roomData.Password, false)
 				return
 			}
 			if buffer[1] == 3 {
@@ -257,12 +316,23 @@ func main() {
 					return
 				}
 
-				// The following buffer content is the room UUID
-				uuid, err := lemonade.DecodeBufferToString(buffer[2:])
+				// The following buffer content is either the room UUID,
+				// or the room UUID and its join options in json
+				message, err := lemonade.DecodeBufferToString(buffer[2:])
 				if err != nil {
 					panic(fmt.Errorf("decode: %w", err))
 				}
-				instance.JoinRoom(uuid)
+
+				var roomData struct {
+					ID       string `json:"id"`
+					Password string `json:"password"`
+					Spectate bool   `json:"spectate"`
+				}
+				if err := json.Unmarshal([]byte(message), &roomData); err != nil {
+					roomData.ID = message
+				}
+
+				instance.JoinRoom(roomData.ID, roomData.Password, roomData.Spectate)
 				return
 			}
 		}
@@ -280,38 +350,13 @@ func main() {
 			if buffer[1] == 2 {
 				// Scenario: (If host), NotITG wants to set a new song
 
-				message, err := lemonade.DecodeBufferToString(buffer[2:])
-				if err != nil {
-					panic(fmt.Errorf("decode: %w", err))
-				}
-
-				// Attempt to read json data
-				var songData struct {
-					Key        string `json:"key"`
-					Difficulty string `json:"difficulty"`
-				}
-
-				if err := json.Unmarshal([]byte(message), &songData); err != nil {
-					instance.Logger.Debug("error while parsing client message", "error", err)
-					instance.AttemptClose()
-					return
-				}
-
-				// Get hash of song
-				if !HasSongKey(db, songData.Key) {
-					instance.Logger.Info(fmt.Sprintf("client has no hash of this song! (%s)\n", songData.Key))
-					instance.Logger.Info("run this program again with -scan")
-					instance.AttemptClose()
-					return
-				}
-				hash, has := GetSongHash(db, songData.Key)
-				if !has {
-					instance.Logger.Info(fmt.Sprintf("could not find song with key: %s\n", songData.Key))
+				song, ok := readSong(buffer[2:])
+				if !ok {
 					instance.AttemptClose()
 					return
 				}
 
-				instance.Room.Send <- events.NewSetSongEvent(hash, songData.Difficulty)
+				instance.Room.Send <- protocol.NewSetSongEvent(song.Hash, song.Difficulty)
 			}
 			if buffer[1] == 3 {
 				// Scenario: NotITG received a song hash, and it wants us to verify if we have it
@@ -323,7 +368,7 @@ func main() {
 
 				// Verify it, and whatever the result is, send it to the server.
 				has := HasSongHash(db, hash)
-				instance.Room.Send <- events.NewUserSongEvent(has)
+				instance.Room.Send <- protocol.NewUserSongEvent(has)
 
 				// Don't have song? Just notify NotITG
 				if !has {
@@ -352,21 +397,143 @@ func main() {
 
 				if state := buffer[2]; state == 0 {
 					// Set state to idle
-					instance.Room.Send <- events.NewUserStateEvent(0)
+					instance.Room.Send <- protocol.NewSetUserStateEvent(0)
 				} else {
 					// Set state to ready
-					instance.Room.Send <- events.NewUserStateEvent(1)
+					instance.Room.Send <- protocol.NewSetUserStateEvent(1)
 				}
 			}
 			if buffer[1] == 5 {
-				instance.Room.Send <- events.NewHostStartEvent()
+				instance.Room.Send <- protocol.NewHostStartEvent()
+			}
+			if buffer[1] == 6 {
+				// Scenario: (If host), NotITG wants to change the room's max player count
+				instance.Room.Send <- protocol.NewSetCapacityEvent(int(buffer[2]))
+			}
+			if buffer[1] == 7 || buffer[1] == 8 {
+				// Scenario: (If host), NotITG wants to kick (or ban) a player
+
+				message, err := lemonade.DecodeBufferToString(buffer[2:])
+				if err != nil {
+					panic(fmt.Errorf("decode: %w", err))
+				}
+
+				var kickData struct {
+					ID     string `json:"id"`
+					Reason string `json:"reason"`
+				}
+				if err := json.Unmarshal([]byte(message), &kickData); err != nil {
+					instance.Logger.Debug("error while parsing client message", "error", err)
+					return
+				}
+
+				if buffer[1] == 7 {
+					instance.Room.Send <- protocol.NewKickEvent(kickData.ID, kickData.Reason)
+				} else {
+					instance.Room.Send <- protocol.NewBanEvent(kickData.ID, kickData.Reason)
+				}
+			}
+			if buffer[1] == 9 {
+				// Scenario: (If host), NotITG wants to hand the host over to another player
+
+				id, err := lemonade.DecodeBufferToString(buffer[2:])
+				if err != nil {
+					panic(fmt.Errorf("decode: %w", err))
+				}
+
+				instance.Room.Send <- protocol.NewHostTransferEvent(id)
+			}
+			if buffer[1] == 10 {
+				// Scenario: NotITG wants to send a chat message to the room
+
+				message, err := lemonade.DecodeBufferToString(buffer[2:])
+				if err != nil {
+					panic(fmt.Errorf("decode: %w", err))
+				}
+
+				instance.Room.Send <- protocol.NewSendChatEvent(message)
+			}
+			if buffer[1] == 11 {
+				// Scenario: (If host), NotITG wants to change the room's settings
+
+				message, err := lemonade.DecodeBufferToString(buffer[2:])
+				if err != nil {
+					panic(fmt.Errorf("decode: %w", err))
+				}
+
+				var settings protocol.RoomSettings
+				if err := json.Unmarshal([]byte(message), &settings); err != nil {
+					instance.Logger.Debug("error while parsing client message", "error", err)
+					return
+				}
+
+				instance.Room.Send <- protocol.NewSetRoomSettingsEvent(settings)
+			}
+			if buffer[1] == 12 {
+				// Scenario: NotITG wants to add a song to the room's queue
+
+				song, ok := readSong(buffer[2:])
+				if !ok {
+					return
+				}
+
+				instance.Room.Send <- protocol.NewQueueAddEvent(song.Hash, song.Difficulty)
+			}
+			if buffer[1] == 13 {
+				// Scenario: NotITG wants to remove a song from the room's queue
+
+				id, err := lemonade.DecodeBufferToString(buffer[2:])
+				if err != nil {
+					panic(fmt.Errorf("decode: %w", err))
+				}
+
+				instance.Room.Send <- protocol.NewQueueRemoveEvent(id)
+			}
+			if buffer[1] == 14 {
+				// Scenario: NotITG wants to move a song in the room's queue
+
+				message, err := lemonade.DecodeBufferToString(buffer[2:])
+				if err != nil {
+					panic(fmt.Errorf("decode: %w", err))
+				}
+
+				var moveData struct {
+					ID    string `json:"id"`
+					Index int    `json:"index"`
+				}
+				if err := json.Unmarshal([]byte(message), &moveData); err != nil {
+					instance.Logger.Debug("error while parsing client message", "error", err)
+					return
+				}
+
+				instance.Room.Send <- protocol.NewQueueMoveEvent(moveData.ID, moveData.Index)
+			}
+			if buffer[1] == 15 {
+				// Scenario: NotITG wants to nominate a song for the room's vote
+
+				song, ok := readSong(buffer[2:])
+				if !ok {
+					return
+				}
+
+				instance.Room.Send <- protocol.NewNominateEvent(song.Hash, song.Difficulty)
+			}
+			if buffer[1] == 16 {
+				// Scenario: NotITG wants to vote for one of the nominated songs
+
+				id, err := lemonade.DecodeBufferToString(buffer[2:])
+				if err != nil {
+					panic(fmt.Errorf("decode: %w", err))
+				}
+
+				instance.Room.Send <- protocol.NewCastVoteEvent(id)
 			}
 		}
 		if buffer[0] == 4 {
 			if buffer[1] == 1 {
 				// Scenario: We're in ScreenGameplay, and NotITG is ready!
 				instance.State = CLIENT_GAME
-				instance.Room.Send <- events.NewGameplayReadyEvent()
+				instance.Room.Send <- protocol.NewGameplayReadyEvent()
 			}
 			if buffer[1] == 2 {
 				// Scenario: Updating scores in real time!
@@ -374,7 +541,7 @@ func main() {
 					return
 				}
 
-				instance.Room.Send <- events.NewGameplayScoreEvent(buffer[2])
+				instance.Room.Send <- protocol.NewSubmitScoreEvent(buffer[2])
 			}
 			if buffer[1] == 3 {
 				// Scenario: We have finished the song! Let's notify the server.
@@ -390,7 +557,7 @@ func main() {
 				boo := buffer[7]
 				miss := buffer[8]
 
-				instance.Room.Send <- events.NewGameplayFinishEvent(score, events.JudgmentScore{
+				instance.Room.Send <- protocol.NewSubmitFinishEvent(score, protocol.Judgments{
 					Marvelous: marvelous,
 					Perfect:   perfect,
 					Great:     great,
@@ -10,11 +10,15 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	lemonade "github.com/Jaezmien/notitg-lemonade-go"
 	"github.com/gorilla/websocket"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
 )
 
 type LemonInstance struct {
@@ -63,6 +67,11 @@ func NewLemonInstance(appID int32) *LemonInstance {
 }
 
 func (i *LemonInstance) AttemptClose() {
+	i.AttemptCloseWithReason("")
+}
+
+// Same as AttemptClose, but lets NotITG display why we're closing
+func (i *LemonInstance) AttemptCloseWithReason(reason string) {
 	if i.Closing {
 		return
 	}
@@ -74,7 +83,11 @@ func (i *LemonInstance) AttemptClose() {
 	}
 
 	i.Logger.Debug("attempting to close properly...")
-	i.Lemon.WriteBuffer([]int32{1, 2})
+	if reason == "" {
+		i.Lemon.WriteBuffer([]int32{1, 2})
+	} else {
+		i.SendString(reason, []int32{1, 3})
+	}
 }
 
 func (i *LemonInstance) Close() {
@@ -91,7 +104,8 @@ func (i *LemonInstance) SendString(data string, prefix []int32) {
 	i.Lemon.WriteBuffer(append(prefix, buff...))
 }
 
-func (i *LemonInstance) JoinRoom(id string) *websocket.Conn {
+// Returns the websocket url of the server's room endpoint
+func (i *LemonInstance) RoomURL(q url.Values) string {
 	re := regexp.MustCompile("https?://")
 	s := re.ReplaceAllString(Server, "")
 
@@ -101,12 +115,27 @@ func (i *LemonInstance) JoinRoom(id string) *websocket.Conn {
 	}
 
 	u := url.URL{Scheme: scheme, Host: s, Path: "/room/join"}
-	q := u.Query()
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func (i *LemonInstance) JoinRoom(id string, password string, spectate bool) *websocket.Conn {
+	q := url.Values{}
 	q.Add("username", Username)
+	if Token != "" {
+		q.Add("token", Token)
+	}
 	q.Add("room", id)
-	u.RawQuery = q.Encode()
+	q.Add("protocol", strconv.Itoa(protocol.ProtocolVersion))
+	if password != "" {
+		q.Add("password", password)
+	}
+	if spectate {
+		q.Add("spectate", "1")
+	}
 
-	c, t, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	c, t, err := websocket.DefaultDialer.Dial(i.RoomURL(q), nil)
 	if err != nil {
 		if errors.Is(err, syscall.ECONNREFUSED) {
 			fmt.Println("server is possibly inactive, exiting.")
@@ -120,24 +149,63 @@ func (i *LemonInstance) JoinRoom(id string) *websocket.Conn {
 				panic(fmt.Errorf("io read: %w", err))
 			}
 			i.Logger.Debug(string(data))
+
+			message := string(data)
+			if t.StatusCode == http.StatusUnauthorized {
+				i.Logger.Info("the server needs you to log in, restart the client with -login")
+			}
+			if t.StatusCode == http.StatusUpgradeRequired {
+				var protocolErr struct {
+					Protocol    int `json:"protocol"`
+					MinProtocol int `json:"min_protocol"`
+				}
+				if err := json.Unmarshal(data, &protocolErr); err == nil {
+					message = fmt.Sprintf(
+						"Version mismatch: this client speaks protocol v%d, but the server only supports v%d to v%d. Please update your client or the server.",
+						protocol.ProtocolVersion, protocolErr.MinProtocol, protocolErr.Protocol,
+					)
+				}
+			}
+
+			// Let NotITG know why we couldn't join the room
+			i.SendString(message, []int32{2, 3})
 		}
 		return nil
 	}
 
 	i.Room = NewRoomConnection(c, i)
+	i.Room.RoomID = id
 	go i.Room.Read()
 	go i.Room.Write()
+	go i.Room.SyncClock()
 
 	i.Lemon.WriteBuffer([]int32{2, 2}) // Send to NotITG that we're in a room
 
 	return c
 }
-func (i *LemonInstance) CreateRoom() string {
+func (i *LemonInstance) CreateRoom(password string, private bool, maxPlayers int) string {
 	p, err := url.JoinPath(Server, "/room/create")
 	if err != nil {
 		panic(fmt.Errorf("join: %w", err))
 	}
-	res, err := http.Post(p, "", nil)
+
+	u, err := url.Parse(p)
+	if err != nil {
+		panic(fmt.Errorf("url: %w", err))
+	}
+	q := u.Query()
+	if password != "" {
+		q.Add("password", password)
+	}
+	if private {
+		q.Add("private", "true")
+	}
+	if maxPlayers > 0 {
+		q.Add("max_players", strconv.Itoa(maxPlayers))
+	}
+	u.RawQuery = q.Encode()
+
+	res, err := http.Post(u.String(), "", nil)
 	if err != nil {
 		panic(fmt.Errorf("http post: %w", err))
 	}
@@ -189,6 +257,13 @@ func (i *LemonInstance) LeaveRoom() {
 	// XXX: We need to state that it's closed, before closing the connection
 	// Otherwise, ReadMessage attempts to read one last message, and hits Fatal
 	i.Room.Closed = true
+
+	// Let the server know that we're leaving on purpose, so it doesn't wait for us to resume
+	i.Room.Connection.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
 	i.Room.Connection.Close()
 	i.Room = nil
 }
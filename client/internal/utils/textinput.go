@@ -78,3 +78,17 @@ func GetTextInput(prompt string, limit int) string {
 
 	return m.Value
 }
+
+// Same as GetTextInput, but hides what's being typed
+func GetPasswordInput(prompt string, limit int) string {
+	m := NewTextModel(prompt, limit)
+	m.input.EchoMode = textinput.EchoPassword
+	m.input.EchoCharacter = '*'
+	p := tea.NewProgram(&m)
+
+	if _, err := p.Run(); err != nil {
+		panic(fmt.Errorf("tea: %w", err))
+	}
+
+	return m.Value
+}
@@ -1,18 +1,42 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
 )
 
+var RoomResumeAttempts = 10
+var RoomResumeInterval = time.Second * 2
+
+var RoomClockSyncSamples = 5
+var RoomClockSyncInterval = time.Millisecond * 200
+
 type RoomConnection struct {
 	Connection *websocket.Conn
 	Instance   *LemonInstance
 	Closed     bool
 
+	RoomID      string
+	ResumeToken string
+
+	// Set once the server has told us that it's shutting down
+	ShutdownReason string
+	// Set once the host has removed us from the room
+	Kicked bool
+
+	// How far ahead the server's clock is from ours, in milliseconds
+	ClockOffset atomic.Int64
+	// The round trip time of the sample that ClockOffset was taken from
+	clockRTT atomic.Int64
+
 	Send chan []byte
 }
 
@@ -32,6 +56,17 @@ func (m *RoomConnection) Read() {
 		}
 
 		m.Connection.Close()
+
+		if m.Kicked {
+			// NotITG will head back to the lobby by itself, which will have us leave the room
+			return
+		}
+
+		if m.ShutdownReason != "" {
+			m.Instance.AttemptCloseWithReason("Server has shut down: " + m.ShutdownReason)
+			return
+		}
+
 		m.Instance.AttemptClose()
 	}()
 
@@ -43,6 +78,15 @@ func (m *RoomConnection) Read() {
 		}
 
 		if err != nil {
+			// We've lost the connection without the server closing it, let's try to get back in.
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && m.ResumeToken != "" && m.ShutdownReason == "" && !m.Kicked {
+				m.Instance.Logger.Info("lost connection to the server, attempting to resume...", slog.Any("error", err))
+				if m.Resume() {
+					m.Instance.Logger.Info("resumed connection to the server!")
+					continue
+				}
+			}
+
 			if _, ok := err.(*websocket.CloseError); ok {
 				m.Instance.Logger.Debug("websocket close error", slog.Any("error", err))
 			} else {
@@ -64,26 +108,144 @@ func (m *RoomConnection) Read() {
 		}
 
 		// Validate json
-		var j json.RawMessage
-		if err := json.Unmarshal(message, &j); err != nil {
+		event, err := protocol.ParseEvent(message)
+		if err != nil {
 			m.Instance.Logger.Warn("invalid server message", slog.String("message", string(message)))
 			continue
 		}
 
+		switch event.Type {
+		case protocol.EVENT_HELLO:
+			if data, err := protocol.ParseHelloEvent(event.Data); err == nil {
+				m.Instance.Logger.Info("connected to server", slog.String("version", data.Version), slog.Int("protocol", data.Protocol), slog.Any("features", data.Features))
+			}
+		case protocol.EVENT_SELF_ERROR:
+			if data, err := protocol.ParseErrorEvent(event.Data); err == nil {
+				m.Instance.Logger.Warn("server could not handle our message", slog.String("type", string(data.Type)), slog.String("message", data.Message))
+			}
+		case protocol.EVENT_SELF_USER:
+			if data, err := protocol.ParseUserInfoEvent(event.Data); err == nil {
+				m.ResumeToken = data.ResumeToken
+			}
+		case protocol.EVENT_SELF_KICKED:
+			m.Instance.Logger.Info("we have been kicked from the room")
+			m.Kicked = true
+		case protocol.EVENT_CLOCK_PONG:
+			if data, err := protocol.ParseClockPongEvent(event.Data); err == nil {
+				m.UpdateClockOffset(data.ClientTime, data.ServerReceive, data.ServerSend, time.Now().UnixMilli())
+			}
+
+			// This one's just for us
+			continue
+		case protocol.EVENT_ROOM_START:
+			// Get a fresh offset while everyone is loading in
+			go m.SyncClock()
+		case protocol.EVENT_GAMEPLAY_START:
+			data, err := protocol.ParseGameplayStartEvent(event.Data)
+			if err != nil || data.StartAt == 0 {
+				break
+			}
+
+			// Hold on to the message until the server's start time, as seen from our clock
+			delay := time.Duration(data.StartAt-m.ClockOffset.Load()-time.Now().UnixMilli()) * time.Millisecond
+			if delay > 0 {
+				m.Instance.Logger.Debug("delaying match start", slog.Duration("delay", delay))
+
+				message := string(message)
+				time.AfterFunc(delay, func() {
+					if m.Closed {
+						return
+					}
+					m.Instance.SendString(message, []int32{99})
+				})
+				continue
+			}
+		case protocol.EVENT_SERVER_SHUTDOWN:
+			if data, err := protocol.ParseServerShutdownEvent(event.Data); err == nil {
+				m.Instance.Logger.Info("server is shutting down", slog.String("reason", data.Reason))
+				m.ShutdownReason = data.Reason
+			}
+		}
+
 		m.Instance.SendString(string(message), []int32{99})
 	}
 }
 
+// Sends a few clock pings to the server, so that we can figure out the difference between our clocks
+func (m *RoomConnection) SyncClock() {
+	m.clockRTT.Store(-1)
+
+	for range RoomClockSyncSamples {
+		if m.Closed {
+			return
+		}
+
+		m.Send <- protocol.NewClockPingEvent(time.Now().UnixMilli())
+		time.Sleep(RoomClockSyncInterval)
+	}
+}
+
+// Takes in a clock sample, keeping it if it has the lowest round trip time so far
+func (m *RoomConnection) UpdateClockOffset(clientSend int64, serverReceive int64, serverSend int64, clientReceive int64) {
+	rtt := (clientReceive - clientSend) - (serverSend - serverReceive)
+	if best := m.clockRTT.Load(); best >= 0 && rtt >= best {
+		return
+	}
+
+	offset := ((serverReceive - clientSend) + (serverSend - clientReceive)) / 2
+	m.clockRTT.Store(rtt)
+	m.ClockOffset.Store(offset)
+
+	m.Instance.Logger.Debug("updated clock offset", slog.Int64("offset", offset), slog.Int64("rtt", rtt))
+}
+
+// Attempts to reattach ourselves to the room we've lost connection to
+func (m *RoomConnection) Resume() bool {
+	q := url.Values{}
+	q.Add("room", m.RoomID)
+	q.Add("resume", m.ResumeToken)
+	q.Add("protocol", strconv.Itoa(protocol.ProtocolVersion))
+
+	for range RoomResumeAttempts {
+		time.Sleep(RoomResumeInterval)
+
+		if m.Closed {
+			return false
+		}
+
+		c, t, err := websocket.DefaultDialer.Dial(m.Instance.RoomURL(q), nil)
+		if err != nil {
+			// The server has given up on us, no point in trying again
+			if t != nil && t.StatusCode == http.StatusBadRequest {
+				m.Instance.Logger.Debug("server rejected resume attempt")
+				return false
+			}
+
+			m.Instance.Logger.Debug("resume attempt failed", slog.Any("error", err))
+			continue
+		}
+
+		m.Connection = c
+		go m.SyncClock()
+		return true
+	}
+
+	return false
+}
+
 func (m *RoomConnection) Write() {
 	for message := range m.Send {
+		// XXX: Messages sent while we're resuming are dropped
 		w, err := m.Connection.NextWriter(websocket.TextMessage)
 		if err != nil {
-			panic(fmt.Errorf("ws writer: %w", err))
+			m.Instance.Logger.Debug("websocket writer error", slog.Any("error", err))
+			continue
 		}
 		w.Write(message)
 
 		if err := w.Close(); err != nil {
-			panic(fmt.Errorf("ws close: %w", err))
+			m.Instance.Logger.Debug("websocket close error", slog.Any("error", err))
+			continue
 		}
 	}
 }
@@ -0,0 +1,324 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Events that are sent by the client, and read by the server
+
+func NewSetSongEvent(hash string, difficulty string) []byte {
+	return newEvent(
+		EVENT_ROOM_SONG,
+		SetSong{hash, difficulty},
+	)
+}
+func ParseRoomSongEvent(raw json.RawMessage) (SetSong, error) {
+	return parse[SetSong](raw)
+}
+
+func NewUserSongEvent(hasSong bool) []byte {
+	return newEvent(
+		EVENT_USER_SONG_STATE,
+		UserSongState{hasSong},
+	)
+}
+func ParseUserSongStateEvent(raw json.RawMessage) (UserSongState, error) {
+	return parse[UserSongState](raw)
+}
+
+func NewSetUserStateEvent(state int) []byte {
+	return newEvent(
+		EVENT_USER_STATE,
+		BaseState{state},
+	)
+}
+func ParseUserStateEvent(raw json.RawMessage) (BaseState, error) {
+	return parse[BaseState](raw)
+}
+
+func NewSetCapacityEvent(maxPlayers int) []byte {
+	return newEvent(
+		EVENT_ROOM_CAPACITY,
+		Capacity{maxPlayers},
+	)
+}
+func ParseRoomCapacityEvent(raw json.RawMessage) (Capacity, error) {
+	data, err := parse[Capacity](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.MaxPlayers < 0 {
+		return data, fmt.Errorf("invalid max players value")
+	}
+
+	return data, nil
+}
+
+func NewSetRoomSettingsEvent(settings RoomSettings) []byte {
+	return newEvent(
+		EVENT_ROOM_SETTINGS,
+		settings,
+	)
+}
+func ParseRoomSettingsEvent(raw json.RawMessage) (RoomSettings, error) {
+	data, err := parse[RoomSettings](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.MaxPlayers < 0 {
+		return data, fmt.Errorf("invalid max players value")
+	}
+
+	minIndex := -1
+	if data.MinDifficulty != "" {
+		if minIndex = DifficultyIndex(data.MinDifficulty); minIndex < 0 {
+			return data, fmt.Errorf("invalid min difficulty value")
+		}
+	}
+	if data.MaxDifficulty != "" {
+		maxIndex := DifficultyIndex(data.MaxDifficulty)
+		if maxIndex < 0 {
+			return data, fmt.Errorf("invalid max difficulty value")
+		}
+		if maxIndex < minIndex {
+			return data, fmt.Errorf("min difficulty is higher than max difficulty")
+		}
+	}
+
+	return data, nil
+}
+
+func NewQueueAddEvent(hash string, difficulty string) []byte {
+	return newEvent(
+		EVENT_QUEUE_ADD,
+		SetSong{hash, difficulty},
+	)
+}
+func ParseQueueAddEvent(raw json.RawMessage) (SetSong, error) {
+	data, err := parse[SetSong](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.Hash == "" {
+		return data, fmt.Errorf("missing song hash")
+	}
+
+	return data, nil
+}
+
+func NewQueueRemoveEvent(id string) []byte {
+	return newEvent(
+		EVENT_QUEUE_REMOVE,
+		BaseID{id},
+	)
+}
+func ParseQueueRemoveEvent(raw json.RawMessage) (BaseID, error) {
+	data, err := parse[BaseID](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.ID == "" {
+		return data, fmt.Errorf("missing entry id")
+	}
+
+	return data, nil
+}
+
+func NewQueueMoveEvent(id string, index int) []byte {
+	return newEvent(
+		EVENT_QUEUE_MOVE,
+		QueueMove{BaseID{id}, index},
+	)
+}
+func ParseQueueMoveEvent(raw json.RawMessage) (QueueMove, error) {
+	data, err := parse[QueueMove](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.ID == "" {
+		return data, fmt.Errorf("missing entry id")
+	}
+	if data.Index < 0 {
+		return data, fmt.Errorf("invalid index value")
+	}
+
+	return data, nil
+}
+
+func NewNominateEvent(hash string, difficulty string) []byte {
+	return newEvent(
+		EVENT_VOTE_NOMINATE,
+		SetSong{hash, difficulty},
+	)
+}
+func ParseNominateEvent(raw json.RawMessage) (SetSong, error) {
+	data, err := parse[SetSong](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.Hash == "" {
+		return data, fmt.Errorf("missing song hash")
+	}
+
+	return data, nil
+}
+
+func NewCastVoteEvent(id string) []byte {
+	return newEvent(
+		EVENT_VOTE_CAST,
+		BaseID{id},
+	)
+}
+func ParseCastVoteEvent(raw json.RawMessage) (BaseID, error) {
+	data, err := parse[BaseID](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.ID == "" {
+		return data, fmt.Errorf("missing nomination id")
+	}
+
+	return data, nil
+}
+
+func NewKickEvent(id string, reason string) []byte {
+	return newEvent(
+		EVENT_USER_KICK,
+		Moderation{BaseID{id}, reason},
+	)
+}
+func NewBanEvent(id string, reason string) []byte {
+	return newEvent(
+		EVENT_USER_BAN,
+		Moderation{BaseID{id}, reason},
+	)
+}
+func ParseModerationEvent(raw json.RawMessage) (Moderation, error) {
+	data, err := parse[Moderation](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.ID == "" {
+		return data, fmt.Errorf("missing user id")
+	}
+
+	return data, nil
+}
+
+func NewHostTransferEvent(id string) []byte {
+	return newEvent(
+		EVENT_HOST_TRANSFER,
+		BaseID{id},
+	)
+}
+func ParseHostTransferEvent(raw json.RawMessage) (BaseID, error) {
+	data, err := parse[BaseID](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.ID == "" {
+		return data, fmt.Errorf("missing user id")
+	}
+
+	return data, nil
+}
+
+func NewSendChatEvent(message string) []byte {
+	return newEvent(
+		EVENT_ROOM_CHAT,
+		ChatMessage{message},
+	)
+}
+func ParseSendChatEvent(raw json.RawMessage) (ChatMessage, error) {
+	return parse[ChatMessage](raw)
+}
+
+func NewClockPingEvent(clientTime int64) []byte {
+	return newEvent(
+		EVENT_CLOCK_PING,
+		ClockPing{clientTime},
+	)
+}
+func ParseClockPingEvent(raw json.RawMessage) (ClockPing, error) {
+	return parse[ClockPing](raw)
+}
+
+func NewHostStartEvent() []byte {
+	return newEvent(
+		EVENT_ROOM_START,
+		Empty{},
+	)
+}
+
+func NewGameplayReadyEvent() []byte {
+	return newEvent(
+		EVENT_USER_READY,
+		Empty{},
+	)
+}
+
+func NewSubmitScoreEvent(score int32) []byte {
+	return newEvent(
+		EVENT_USER_SCORE,
+		GameplayScore{score},
+	)
+}
+func ParseSubmitScoreEvent(raw json.RawMessage) (GameplayScore, error) {
+	data, err := parse[GameplayScore](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.Score < 0 {
+		return data, fmt.Errorf("invalid score value")
+	}
+
+	return data, nil
+}
+
+func NewSubmitFinishEvent(score int32, judgments Judgments) []byte {
+	return newEvent(
+		EVENT_USER_FINISH,
+		GameplayFinish{GameplayScore{score}, judgments},
+	)
+}
+func ParseSubmitFinishEvent(raw json.RawMessage) (GameplayFinish, error) {
+	data, err := parse[GameplayFinish](raw)
+	if err != nil {
+		return data, err
+	}
+
+	if data.Score < 0 {
+		return data, fmt.Errorf("invalid score value")
+	}
+	if data.Marvelous < 0 {
+		return data, fmt.Errorf("invalid judgment value")
+	}
+	if data.Perfect < 0 {
+		return data, fmt.Errorf("invalid judgment value")
+	}
+	if data.Great < 0 {
+		return data, fmt.Errorf("invalid judgment value")
+	}
+	if data.Good < 0 {
+		return data, fmt.Errorf("invalid judgment value")
+	}
+	if data.Boo < 0 {
+		return data, fmt.Errorf("invalid judgment value")
+	}
+	if data.Miss < 0 {
+		return data, fmt.Errorf("invalid judgment value")
+	}
+
+	return data, nil
+}
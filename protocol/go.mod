@@ -0,0 +1,3 @@
+module git.jaezmien.com/Jaezmien/notitg-party/protocol
+
+go 1.24.0
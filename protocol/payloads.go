@@ -0,0 +1,181 @@
+package protocol
+
+type BaseID struct {
+	ID string `json:"id"`
+}
+type SelfUser struct {
+	BaseID
+	ResumeToken string `json:"resume_token"`
+}
+type User struct {
+	BaseID
+	Username string `json:"username"`
+}
+type Title struct {
+	Title string `json:"title"`
+}
+type SetSong struct {
+	Hash       string `json:"hash"`
+	Difficulty string `json:"difficulty"`
+}
+type Capacity struct {
+	MaxPlayers int `json:"max_players"`
+}
+type RoomSettings struct {
+	Title      string `json:"title"`
+	MaxPlayers int    `json:"max_players"`
+	Private    bool   `json:"private"`
+
+	// Starts the match as soon as every player is ready, without waiting for the host
+	AutoStart bool `json:"auto_start"`
+
+	// The range of difficulties that the host can pick from, empty if unbounded
+	MinDifficulty string `json:"min_difficulty"`
+	MaxDifficulty string `json:"max_difficulty"`
+
+	// Lets everyone add, remove and move songs in the queue, not just the host
+	OpenQueue bool `json:"open_queue"`
+
+	// Picks the next song by having the players nominate and vote on songs
+	Voting bool `json:"voting"`
+}
+type QueueEntry struct {
+	BaseID
+	SetSong
+
+	// The user that added the song
+	AddedBy User `json:"added_by"`
+}
+type QueueMove struct {
+	BaseID
+	Index int `json:"index"`
+}
+type Queue struct {
+	Entries []QueueEntry `json:"entries"`
+}
+type Nomination struct {
+	BaseID
+	SetSong
+
+	NominatedBy User `json:"nominated_by"`
+	Votes       int  `json:"votes"`
+}
+type Vote struct {
+	BaseState
+
+	// When the current phase ends, in server time
+	EndsAt int64 `json:"ends_at"`
+
+	Nominations []Nomination `json:"nominations"`
+
+	// The id of the nomination that has won, once the vote is over
+	Winner string `json:"winner"`
+}
+type BaseState struct {
+	State int `json:"state"`
+}
+type UserSongState struct {
+	HasSong bool `json:"has_song"`
+}
+type UserJoin struct {
+	User
+	BaseState
+	Spectator bool `json:"spectator"`
+}
+type UserState struct {
+	BaseID
+	BaseState
+}
+
+type Moderation struct {
+	BaseID
+	Reason string `json:"reason"`
+}
+type Kicked struct {
+	Reason string `json:"reason"`
+	Banned bool   `json:"banned"`
+}
+
+type ChatMessage struct {
+	Message string `json:"message"`
+}
+type Chat struct {
+	User
+	ChatMessage
+	Timestamp int64 `json:"timestamp"`
+}
+type ChatRejected struct {
+	Reason string `json:"reason"`
+}
+
+type UserLatency struct {
+	BaseID
+	Latency int64 `json:"latency"`
+}
+type RoomLatency struct {
+	Users []UserLatency `json:"users"`
+}
+
+type ClockPing struct {
+	ClientTime int64 `json:"client_time"`
+}
+type ClockPong struct {
+	ClockPing
+	ServerReceive int64 `json:"server_receive"`
+	ServerSend    int64 `json:"server_send"`
+}
+
+type GameplayStart struct {
+	StartAt int64 `json:"start_at"`
+}
+type GameplayScore struct {
+	Score int32 `json:"score"`
+}
+type GameplayScoreWithUserID struct {
+	BaseID
+	GameplayScore
+}
+
+type Judgments struct {
+	Marvelous int32 `json:"marvelous"`
+	Perfect   int32 `json:"perfect"`
+	Great     int32 `json:"great"`
+	Good      int32 `json:"good"`
+	Boo       int32 `json:"boo"`
+	Miss      int32 `json:"miss"`
+}
+type GameplayFinish struct {
+	GameplayScore
+	Judgments
+}
+type GameplayFinishWithUserID struct {
+	BaseID
+	GameplayFinish
+}
+
+type Standing struct {
+	Rank int `json:"rank"`
+	User
+	Status string `json:"status"`
+	GameplayFinish
+}
+type EvaluationReveal struct {
+	Standings []Standing `json:"standings"`
+}
+
+type ServerShutdown struct {
+	Reason string `json:"reason"`
+	ETA    int64  `json:"eta"`
+}
+
+type Hello struct {
+	Version  string   `json:"version"`
+	Protocol int      `json:"protocol"`
+	Features []string `json:"features"`
+}
+type Error struct {
+	Type    EventType `json:"type"`
+	Message string    `json:"message"`
+}
+
+type Empty struct{}
@@ -0,0 +1,130 @@
+// Package protocol holds every event that is sent between the client and the server.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Bumped whenever an event changes in a way that older clients can't handle
+const ProtocolVersion = 1
+
+// Clients can also pick the protocol through the websocket subprotocol, e.g. "notitg-party.v1"
+const ProtocolSubprotocolPrefix = "notitg-party.v"
+
+type EventType string
+
+// Client -> Server
+const (
+	EVENT_USER_SONG_STATE EventType = "room.user.song"
+	EVENT_USER_STATE      EventType = "room.user.state"
+	EVENT_USER_READY      EventType = "room.game.ready"
+	EVENT_USER_SCORE      EventType = "room.game.score"
+	EVENT_USER_FINISH     EventType = "room.game.finish"
+	EVENT_USER_KICK       EventType = "room.user.kick"
+	EVENT_USER_BAN        EventType = "room.user.ban"
+
+	EVENT_ROOM_SONG     EventType = "room.song"
+	EVENT_ROOM_START    EventType = "room.start"
+	EVENT_ROOM_CAPACITY EventType = "room.capacity"
+	EVENT_ROOM_SETTINGS EventType = "room.settings"
+
+	EVENT_QUEUE_ADD    EventType = "room.queue.add"
+	EVENT_QUEUE_REMOVE EventType = "room.queue.remove"
+	EVENT_QUEUE_MOVE   EventType = "room.queue.move"
+
+	EVENT_VOTE_NOMINATE EventType = "room.vote.nominate"
+	EVENT_VOTE_CAST     EventType = "room.vote.cast"
+
+	EVENT_HOST_TRANSFER EventType = "room.host.transfer"
+
+	EVENT_ROOM_CHAT EventType = "room.chat"
+
+	EVENT_CLOCK_PING EventType = "clock.ping"
+)
+
+// Server -> Client
+// XXX: room.user.state, room.game.score, room.game.finish, room.chat and room.start are sent both ways
+const (
+	EVENT_HELLO               EventType = "hello"
+	EVENT_SERVER_SHUTDOWN     EventType = "server.shutdown"
+	EVENT_CLOCK_PONG          EventType = "clock.pong"
+	EVENT_SELF_USER           EventType = "self.user"
+	EVENT_SELF_KICKED         EventType = "self.kicked"
+	EVENT_SELF_ERROR          EventType = "self.error"
+	EVENT_SELF_CHAT_REJECTED  EventType = "self.chat.rejected"
+	EVENT_USER_JOIN           EventType = "room.user.join"
+	EVENT_USER_LEAVE          EventType = "room.user.leave"
+	EVENT_USER_RECONNECTING   EventType = "room.user.reconnecting"
+	EVENT_USER_RESUME         EventType = "room.user.resume"
+	EVENT_USER_LATENCY        EventType = "room.user.latency"
+	EVENT_ROOM_INFO_ID        EventType = "room.info.id"
+	EVENT_ROOM_INFO_TITLE     EventType = "room.info.title"
+	EVENT_ROOM_INFO_HOST      EventType = "room.info.host"
+	EVENT_ROOM_INFO_SONG      EventType = "room.info.song"
+	EVENT_ROOM_INFO_CAPACITY  EventType = "room.info.capacity"
+	EVENT_ROOM_INFO_SETTINGS  EventType = "room.info.settings"
+	EVENT_ROOM_INFO_QUEUE     EventType = "room.info.queue"
+	EVENT_ROOM_INFO_VOTE      EventType = "room.info.vote"
+	EVENT_ROOM_STATE          EventType = "room.state"
+	EVENT_GAMEPLAY_START      EventType = "room.game.start"
+	EVENT_EVALUATION_STANDING EventType = "room.eval.show"
+)
+
+// The standard difficulties, from easiest to hardest.
+// Edits aren't part of the list, as they can be of any difficulty.
+var Difficulties = []string{"beginner", "easy", "medium", "hard", "challenge"}
+
+// Returns the position of the difficulty in Difficulties, or -1 if it isn't one of them
+func DifficultyIndex(difficulty string) int {
+	for i, d := range Difficulties {
+		if d == difficulty {
+			return i
+		}
+	}
+	return -1
+}
+
+type RawEvent struct {
+	Type EventType       `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type Event struct {
+	Type EventType `json:"type"`
+	Data any       `json:"data"`
+}
+
+func newEvent(t EventType, data any) []byte {
+	res, err := json.Marshal(Event{
+		Type: t,
+		Data: data,
+	})
+	if err != nil {
+		panic(fmt.Errorf("json: %w", err))
+	}
+	return res
+}
+
+func parse[T any](raw json.RawMessage) (T, error) {
+	var data T
+
+	err := json.Unmarshal(raw, &data)
+	if err != nil {
+		return data, fmt.Errorf("invalid json data: %w", err)
+	}
+
+	return data, nil
+}
+
+// Reads the type of the message, leaving the data to be parsed by the event's own parser
+func ParseEvent(message []byte) (RawEvent, error) {
+	var event RawEvent
+
+	err := json.Unmarshal(message, &event)
+	if err != nil {
+		return event, fmt.Errorf("invalid json data: %w", err)
+	}
+
+	return event, nil
+}
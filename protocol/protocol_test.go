@@ -0,0 +1,183 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type roundTrip struct {
+	name    string
+	message []byte
+	typ     EventType
+	parse   func(json.RawMessage) (any, error)
+	want    any
+}
+
+func decoder[T any](f func(json.RawMessage) (T, error)) func(json.RawMessage) (any, error) {
+	return func(raw json.RawMessage) (any, error) {
+		return f(raw)
+	}
+}
+
+func runRoundTrips(t *testing.T, cases []roundTrip) {
+	t.Helper()
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			event, err := ParseEvent(c.message)
+			if err != nil {
+				t.Fatalf("ParseEvent: %v", err)
+			}
+			if event.Type != c.typ {
+				t.Fatalf("type = %q, want %q", event.Type, c.typ)
+			}
+
+			if c.parse == nil {
+				return
+			}
+
+			got, err := c.parse(event.Data)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestClientToServer(t *testing.T) {
+	judgments := Judgments{Marvelous: 10, Perfect: 5, Great: 4, Good: 3, Boo: 2, Miss: 1}
+	settings := RoomSettings{"party", 4, true, true, "easy", "hard", false, true}
+
+	runRoundTrips(t, []roundTrip{
+		{"set song", NewSetSongEvent("abc", "hard"), EVENT_ROOM_SONG, decoder(ParseRoomSongEvent), SetSong{"abc", "hard"}},
+		{"user song", NewUserSongEvent(true), EVENT_USER_SONG_STATE, decoder(ParseUserSongStateEvent), UserSongState{true}},
+		{"user state", NewSetUserStateEvent(1), EVENT_USER_STATE, decoder(ParseUserStateEvent), BaseState{1}},
+		{"capacity", NewSetCapacityEvent(4), EVENT_ROOM_CAPACITY, decoder(ParseRoomCapacityEvent), Capacity{4}},
+		{"settings", NewSetRoomSettingsEvent(settings), EVENT_ROOM_SETTINGS, decoder(ParseRoomSettingsEvent), settings},
+		{"unbounded settings", NewSetRoomSettingsEvent(RoomSettings{Title: "party"}), EVENT_ROOM_SETTINGS, decoder(ParseRoomSettingsEvent), RoomSettings{Title: "party"}},
+		{"queue add", NewQueueAddEvent("abc", "hard"), EVENT_QUEUE_ADD, decoder(ParseQueueAddEvent), SetSong{"abc", "hard"}},
+		{"queue remove", NewQueueRemoveEvent("id"), EVENT_QUEUE_REMOVE, decoder(ParseQueueRemoveEvent), BaseID{"id"}},
+		{"queue move", NewQueueMoveEvent("id", 2), EVENT_QUEUE_MOVE, decoder(ParseQueueMoveEvent), QueueMove{BaseID{"id"}, 2}},
+		{"nominate", NewNominateEvent("abc", "hard"), EVENT_VOTE_NOMINATE, decoder(ParseNominateEvent), SetSong{"abc", "hard"}},
+		{"cast vote", NewCastVoteEvent("id"), EVENT_VOTE_CAST, decoder(ParseCastVoteEvent), BaseID{"id"}},
+		{"kick", NewKickEvent("id", "rude"), EVENT_USER_KICK, decoder(ParseModerationEvent), Moderation{BaseID{"id"}, "rude"}},
+		{"ban", NewBanEvent("id", ""), EVENT_USER_BAN, decoder(ParseModerationEvent), Moderation{BaseID{"id"}, ""}},
+		{"host transfer", NewHostTransferEvent("id"), EVENT_HOST_TRANSFER, decoder(ParseHostTransferEvent), BaseID{"id"}},
+		{"chat", NewSendChatEvent("hello"), EVENT_ROOM_CHAT, decoder(ParseSendChatEvent), ChatMessage{"hello"}},
+		{"clock ping", NewClockPingEvent(1234), EVENT_CLOCK_PING, decoder(ParseClockPingEvent), ClockPing{1234}},
+		{"host start", NewHostStartEvent(), EVENT_ROOM_START, nil, nil},
+		{"gameplay ready", NewGameplayReadyEvent(), EVENT_USER_READY, nil, nil},
+		{"score", NewSubmitScoreEvent(500), EVENT_USER_SCORE, decoder(ParseSubmitScoreEvent), GameplayScore{500}},
+		{"finish", NewSubmitFinishEvent(1000, judgments), EVENT_USER_FINISH, decoder(ParseSubmitFinishEvent), GameplayFinish{GameplayScore{1000}, judgments}},
+	})
+}
+
+func TestServerToClient(t *testing.T) {
+	judgments := Judgments{Marvelous: 10, Perfect: 5, Great: 4, Good: 3, Boo: 2, Miss: 1}
+	standings := []Standing{
+		{1, User{BaseID{"a"}, "alice"}, "finished", GameplayFinish{GameplayScore{1000}, judgments}},
+		{2, User{BaseID{"b"}, "bob"}, "disconnected", GameplayFinish{GameplayScore{20}, Judgments{}}},
+	}
+	latency := []UserLatency{{BaseID{"a"}, 20}, {BaseID{"b"}, 150}}
+	settings := RoomSettings{"party", 4, false, true, "", "challenge", true, false}
+	queue := []QueueEntry{
+		{BaseID{"1"}, SetSong{"abc", "hard"}, User{BaseID{"a"}, "alice"}},
+		{BaseID{"2"}, SetSong{"def", "My Edit"}, User{BaseID{"b"}, "bob"}},
+	}
+	vote := Vote{
+		BaseState{2},
+		1234,
+		[]Nomination{
+			{BaseID{"1"}, SetSong{"abc", "hard"}, User{BaseID{"a"}, "alice"}, 2},
+			{BaseID{"2"}, SetSong{"def", "easy"}, User{BaseID{"b"}, "bob"}, 0},
+		},
+		"",
+	}
+
+	runRoundTrips(t, []roundTrip{
+		{"hello", NewHelloEvent("1.0.0", ProtocolVersion, []string{"chat"}), EVENT_HELLO, decoder(ParseHelloEvent), Hello{"1.0.0", ProtocolVersion, []string{"chat"}}},
+		{"error", NewErrorEvent(EVENT_ROOM_CHAT, "bad"), EVENT_SELF_ERROR, decoder(ParseErrorEvent), Error{EVENT_ROOM_CHAT, "bad"}},
+		{"shutdown", NewServerShutdownEvent("bye", 99), EVENT_SERVER_SHUTDOWN, decoder(ParseServerShutdownEvent), ServerShutdown{"bye", 99}},
+		{"self user", NewUserInfoEvent("id", "token"), EVENT_SELF_USER, decoder(ParseUserInfoEvent), SelfUser{BaseID{"id"}, "token"}},
+		{"kicked", NewKickedEvent("rude", true), EVENT_SELF_KICKED, decoder(ParseKickedEvent), Kicked{"rude", true}},
+		{"user join", NewUserJoinEvent("alice", "id", 2, true), EVENT_USER_JOIN, decoder(ParseUserJoinEvent), UserJoin{User{BaseID{"id"}, "alice"}, BaseState{2}, true}},
+		{"user leave", NewUserLeaveEvent("id"), EVENT_USER_LEAVE, decoder(ParseIDEvent), BaseID{"id"}},
+		{"user reconnecting", NewUserReconnectingEvent("id"), EVENT_USER_RECONNECTING, decoder(ParseIDEvent), BaseID{"id"}},
+		{"user resume", NewUserResumeEvent("id"), EVENT_USER_RESUME, decoder(ParseIDEvent), BaseID{"id"}},
+		{"user latency", NewUserLatencyEvent(latency), EVENT_USER_LATENCY, decoder(ParseUserLatencyEvent), RoomLatency{latency}},
+		{"user state", NewUserStateEvent("id", 3), EVENT_USER_STATE, decoder(ParseUserStateChangeEvent), UserState{BaseID{"id"}, BaseState{3}}},
+		{"room id", NewRoomIDEvent("id"), EVENT_ROOM_INFO_ID, decoder(ParseIDEvent), BaseID{"id"}},
+		{"room host", NewRoomHostEvent("id"), EVENT_ROOM_INFO_HOST, decoder(ParseIDEvent), BaseID{"id"}},
+		{"room title", NewRoomTitleEvent("party"), EVENT_ROOM_INFO_TITLE, decoder(ParseRoomTitleEvent), Title{"party"}},
+		{"room song", NewRoomSongEvent("abc", "hard"), EVENT_ROOM_INFO_SONG, decoder(ParseRoomSongEvent), SetSong{"abc", "hard"}},
+		{"room capacity", NewRoomCapacityEvent(8), EVENT_ROOM_INFO_CAPACITY, decoder(ParseRoomCapacityEvent), Capacity{8}},
+		{"room settings", NewRoomSettingsEvent(settings), EVENT_ROOM_INFO_SETTINGS, decoder(ParseRoomInfoSettingsEvent), settings},
+		{"room queue", NewRoomQueueEvent(queue), EVENT_ROOM_INFO_QUEUE, decoder(ParseRoomQueueEvent), Queue{queue}},
+		{"room vote", NewRoomVoteEvent(vote), EVENT_ROOM_INFO_VOTE, decoder(ParseRoomVoteEvent), vote},
+		{"room state", NewRoomStateEvent(1), EVENT_ROOM_STATE, decoder(ParseRoomStateEvent), BaseState{1}},
+		{"room start", NewRoomStartEvent(), EVENT_ROOM_START, nil, nil},
+		{"chat", NewChatEvent("alice", "id", "hi", 42), EVENT_ROOM_CHAT, decoder(ParseChatEvent), Chat{User{BaseID{"id"}, "alice"}, ChatMessage{"hi"}, 42}},
+		{"chat rejected", NewChatRejectedEvent("slow down"), EVENT_SELF_CHAT_REJECTED, decoder(ParseChatRejectedEvent), ChatRejected{"slow down"}},
+		{"clock pong", NewClockPongEvent(1, 2, 3), EVENT_CLOCK_PONG, decoder(ParseClockPongEvent), ClockPong{ClockPing{1}, 2, 3}},
+		{"gameplay start", NewGameplayStartEvent(1000), EVENT_GAMEPLAY_START, decoder(ParseGameplayStartEvent), GameplayStart{1000}},
+		{"gameplay score", NewGameplayScoreEvent("id", 500), EVENT_USER_SCORE, decoder(ParseGameplayScoreEvent), GameplayScoreWithUserID{BaseID{"id"}, GameplayScore{500}}},
+		{"gameplay finish", NewGameplayFinishEvent("id", 1000, judgments), EVENT_USER_FINISH, decoder(ParseGameplayFinishEvent), GameplayFinishWithUserID{BaseID{"id"}, GameplayFinish{GameplayScore{1000}, judgments}}},
+		{"evaluation", NewEvaluationRevealEvent(standings), EVENT_EVALUATION_STANDING, decoder(ParseEvaluationRevealEvent), EvaluationReveal{standings}},
+	})
+}
+
+// The theme reads these by name, so the wire format must not change by accident
+func TestWireFormat(t *testing.T) {
+	cases := map[string][]byte{
+		`{"type":"room.game.finish","data":{"score":1000,"marvelous":1,"perfect":2,"great":3,"good":4,"boo":5,"miss":6}}`: NewSubmitFinishEvent(1000, Judgments{1, 2, 3, 4, 5, 6}),
+		`{"type":"room.game.score","data":{"id":"a","score":5}}`:                                                          NewGameplayScoreEvent("a", 5),
+		`{"type":"room.user.join","data":{"id":"a","username":"alice","state":0,"spectator":false}}`:                      NewUserJoinEvent("alice", "a", 0, false),
+		`{"type":"room.start","data":{}}`: NewHostStartEvent(),
+		`{"type":"room.info.settings","data":{"title":"party","max_players":4,"private":false,"auto_start":true,"min_difficulty":"easy","max_difficulty":"","open_queue":false,"voting":true}}`: NewRoomSettingsEvent(RoomSettings{"party", 4, false, true, "easy", "", false, true}),
+		`{"type":"room.info.vote","data":{"state":0,"ends_at":0,"nominations":[],"winner":"1"}}`:                                                                                                NewRoomVoteEvent(Vote{BaseState{0}, 0, []Nomination{}, "1"}),
+		`{"type":"room.info.queue","data":{"entries":[{"id":"1","hash":"abc","difficulty":"hard","added_by":{"id":"a","username":"alice"}}]}}`:                                                  NewRoomQueueEvent([]QueueEntry{{BaseID{"1"}, SetSong{"abc", "hard"}, User{BaseID{"a"}, "alice"}}}),
+	}
+
+	for want, got := range cases {
+		if string(got) != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestParseRejectsInvalidValues(t *testing.T) {
+	cases := []struct {
+		name  string
+		parse func(json.RawMessage) (any, error)
+		raw   string
+	}{
+		{"negative capacity", decoder(ParseRoomCapacityEvent), `{"max_players":-1}`},
+		{"missing moderation id", decoder(ParseModerationEvent), `{"reason":"x"}`},
+		{"missing transfer id", decoder(ParseHostTransferEvent), `{}`},
+		{"negative settings capacity", decoder(ParseRoomSettingsEvent), `{"title":"x","max_players":-1}`},
+		{"unknown difficulty", decoder(ParseRoomSettingsEvent), `{"title":"x","min_difficulty":"expert"}`},
+		{"edit difficulty bound", decoder(ParseRoomSettingsEvent), `{"title":"x","max_difficulty":"edit"}`},
+		{"inverted difficulty range", decoder(ParseRoomSettingsEvent), `{"title":"x","min_difficulty":"hard","max_difficulty":"easy"}`},
+		{"missing queue hash", decoder(ParseQueueAddEvent), `{"difficulty":"hard"}`},
+		{"missing queue remove id", decoder(ParseQueueRemoveEvent), `{}`},
+		{"missing queue move id", decoder(ParseQueueMoveEvent), `{"index":1}`},
+		{"negative queue index", decoder(ParseQueueMoveEvent), `{"id":"1","index":-1}`},
+		{"missing nomination hash", decoder(ParseNominateEvent), `{"difficulty":"hard"}`},
+		{"missing vote id", decoder(ParseCastVoteEvent), `{}`},
+		{"negative score", decoder(ParseSubmitScoreEvent), `{"score":-1}`},
+		{"negative judgment", decoder(ParseSubmitFinishEvent), `{"score":1,"miss":-1}`},
+		{"malformed", decoder(ParseRoomSongEvent), `{"hash":1}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.parse(json.RawMessage(c.raw)); err == nil {
+				t.Fatalf("expected an error for %s", c.raw)
+			}
+		})
+	}
+}
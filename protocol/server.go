@@ -0,0 +1,270 @@
+package protocol
+
+import (
+	"encoding/json"
+)
+
+// Events that are sent by the server, and read by the client
+
+func NewHelloEvent(version string, protocol int, features []string) []byte {
+	return newEvent(
+		EVENT_HELLO,
+		Hello{version, protocol, features},
+	)
+}
+func ParseHelloEvent(raw json.RawMessage) (Hello, error) {
+	return parse[Hello](raw)
+}
+
+func NewErrorEvent(t EventType, message string) []byte {
+	return newEvent(
+		EVENT_SELF_ERROR,
+		Error{t, message},
+	)
+}
+func ParseErrorEvent(raw json.RawMessage) (Error, error) {
+	return parse[Error](raw)
+}
+
+func NewServerShutdownEvent(reason string, eta int64) []byte {
+	return newEvent(
+		EVENT_SERVER_SHUTDOWN,
+		ServerShutdown{reason, eta},
+	)
+}
+func ParseServerShutdownEvent(raw json.RawMessage) (ServerShutdown, error) {
+	return parse[ServerShutdown](raw)
+}
+
+func NewUserInfoEvent(id string, resumeToken string) []byte {
+	return newEvent(
+		EVENT_SELF_USER,
+		SelfUser{BaseID{id}, resumeToken},
+	)
+}
+func ParseUserInfoEvent(raw json.RawMessage) (SelfUser, error) {
+	return parse[SelfUser](raw)
+}
+
+func NewKickedEvent(reason string, banned bool) []byte {
+	return newEvent(
+		EVENT_SELF_KICKED,
+		Kicked{reason, banned},
+	)
+}
+func ParseKickedEvent(raw json.RawMessage) (Kicked, error) {
+	return parse[Kicked](raw)
+}
+
+func NewUserJoinEvent(username string, id string, state int, spectator bool) []byte {
+	return newEvent(
+		EVENT_USER_JOIN,
+		UserJoin{
+			User{BaseID{id}, username},
+			BaseState{state},
+			spectator,
+		},
+	)
+}
+func ParseUserJoinEvent(raw json.RawMessage) (UserJoin, error) {
+	return parse[UserJoin](raw)
+}
+
+func NewUserLeaveEvent(id string) []byte {
+	return newEvent(
+		EVENT_USER_LEAVE,
+		BaseID{id},
+	)
+}
+func NewUserReconnectingEvent(id string) []byte {
+	return newEvent(
+		EVENT_USER_RECONNECTING,
+		BaseID{id},
+	)
+}
+func NewUserResumeEvent(id string) []byte {
+	return newEvent(
+		EVENT_USER_RESUME,
+		BaseID{id},
+	)
+}
+func ParseIDEvent(raw json.RawMessage) (BaseID, error) {
+	return parse[BaseID](raw)
+}
+
+func NewUserLatencyEvent(users []UserLatency) []byte {
+	return newEvent(
+		EVENT_USER_LATENCY,
+		RoomLatency{users},
+	)
+}
+func ParseUserLatencyEvent(raw json.RawMessage) (RoomLatency, error) {
+	return parse[RoomLatency](raw)
+}
+
+func NewUserStateEvent(id string, state int) []byte {
+	return newEvent(
+		EVENT_USER_STATE,
+		UserState{BaseID{id}, BaseState{state}},
+	)
+}
+func ParseUserStateChangeEvent(raw json.RawMessage) (UserState, error) {
+	return parse[UserState](raw)
+}
+
+func NewRoomIDEvent(id string) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_ID,
+		BaseID{id},
+	)
+}
+func NewRoomHostEvent(id string) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_HOST,
+		BaseID{id},
+	)
+}
+
+func NewRoomTitleEvent(title string) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_TITLE,
+		Title{title},
+	)
+}
+func ParseRoomTitleEvent(raw json.RawMessage) (Title, error) {
+	return parse[Title](raw)
+}
+
+func NewRoomSongEvent(hash string, difficulty string) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_SONG,
+		SetSong{hash, difficulty},
+	)
+}
+
+func NewRoomCapacityEvent(maxPlayers int) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_CAPACITY,
+		Capacity{maxPlayers},
+	)
+}
+
+func NewRoomSettingsEvent(settings RoomSettings) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_SETTINGS,
+		settings,
+	)
+}
+func ParseRoomInfoSettingsEvent(raw json.RawMessage) (RoomSettings, error) {
+	return parse[RoomSettings](raw)
+}
+
+func NewRoomQueueEvent(entries []QueueEntry) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_QUEUE,
+		Queue{entries},
+	)
+}
+func ParseRoomQueueEvent(raw json.RawMessage) (Queue, error) {
+	return parse[Queue](raw)
+}
+
+func NewRoomVoteEvent(vote Vote) []byte {
+	return newEvent(
+		EVENT_ROOM_INFO_VOTE,
+		vote,
+	)
+}
+func ParseRoomVoteEvent(raw json.RawMessage) (Vote, error) {
+	return parse[Vote](raw)
+}
+
+func NewRoomStateEvent(state int) []byte {
+	return newEvent(
+		EVENT_ROOM_STATE,
+		BaseState{state},
+	)
+}
+func ParseRoomStateEvent(raw json.RawMessage) (BaseState, error) {
+	return parse[BaseState](raw)
+}
+
+func NewRoomStartEvent() []byte {
+	return newEvent(
+		EVENT_ROOM_START,
+		Empty{},
+	)
+}
+
+func NewChatEvent(username string, id string, message string, timestamp int64) []byte {
+	return newEvent(
+		EVENT_ROOM_CHAT,
+		Chat{User{BaseID{id}, username}, ChatMessage{message}, timestamp},
+	)
+}
+func ParseChatEvent(raw json.RawMessage) (Chat, error) {
+	return parse[Chat](raw)
+}
+
+func NewChatRejectedEvent(reason string) []byte {
+	return newEvent(
+		EVENT_SELF_CHAT_REJECTED,
+		ChatRejected{reason},
+	)
+}
+func ParseChatRejectedEvent(raw json.RawMessage) (ChatRejected, error) {
+	return parse[ChatRejected](raw)
+}
+
+func NewClockPongEvent(clientTime int64, serverReceive int64, serverSend int64) []byte {
+	return newEvent(
+		EVENT_CLOCK_PONG,
+		ClockPong{ClockPing{clientTime}, serverReceive, serverSend},
+	)
+}
+func ParseClockPongEvent(raw json.RawMessage) (ClockPong, error) {
+	return parse[ClockPong](raw)
+}
+
+func NewGameplayStartEvent(startAt int64) []byte {
+	return newEvent(
+		EVENT_GAMEPLAY_START,
+		GameplayStart{startAt},
+	)
+}
+func ParseGameplayStartEvent(raw json.RawMessage) (GameplayStart, error) {
+	return parse[GameplayStart](raw)
+}
+
+func NewGameplayScoreEvent(id string, score int32) []byte {
+	return newEvent(
+		EVENT_USER_SCORE,
+		GameplayScoreWithUserID{BaseID{id}, GameplayScore{score}},
+	)
+}
+func ParseGameplayScoreEvent(raw json.RawMessage) (GameplayScoreWithUserID, error) {
+	return parse[GameplayScoreWithUserID](raw)
+}
+
+func NewGameplayFinishEvent(id string, score int32, judgments Judgments) []byte {
+	return newEvent(
+		EVENT_USER_FINISH,
+		GameplayFinishWithUserID{
+			BaseID{id},
+			GameplayFinish{GameplayScore{score}, judgments},
+		},
+	)
+}
+func ParseGameplayFinishEvent(raw json.RawMessage) (GameplayFinishWithUserID, error) {
+	return parse[GameplayFinishWithUserID](raw)
+}
+
+func NewEvaluationRevealEvent(standings []Standing) []byte {
+	return newEvent(
+		EVENT_EVALUATION_STANDING,
+		EvaluationReveal{standings},
+	)
+}
+func ParseEvaluationRevealEvent(raw json.RawMessage) (EvaluationReveal, error) {
+	return parse[EvaluationReveal](raw)
+}
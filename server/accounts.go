@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	BUCKET_ACCOUNTS = "accounts"
+	BUCKET_SESSIONS = "sessions"
+)
+
+var AccountsPath = "accounts.db"
+
+// How long a login lasts
+var AccountSessionTTL = time.Hour * 24 * 30
+
+// Only allow players with an account to join rooms
+var AccountsRequireLogin = false
+
+var AccountPasswordMinLength = 8
+
+var ErrUsernameTaken = errors.New("username is already taken")
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Used when the account doesn't exist, so that logging in takes just as long either way
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("notitg-party"), bcrypt.DefaultCost)
+
+type Account struct {
+	Username     string `json:"username"`
+	PasswordHash []byte `json:"password_hash"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+type Session struct {
+	Username  string `json:"username"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type Accounts struct {
+	DB *bolt.DB
+}
+
+func OpenAccounts(path string) (*Accounts, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(BUCKET_ACCOUNTS)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(BUCKET_SESSIONS)); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Accounts{DB: db}, nil
+}
+
+func (a *Accounts) Close() error {
+	return a.DB.Close()
+}
+
+// Usernames are unique regardless of their case, or of any lookalike characters
+func accountKey(username string) []byte {
+	return []byte(NormalizeName(username))
+}
+
+// Sessions are stored by their hash, so the database alone can't be used to log in
+func sessionKey(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+func ValidatePassword(password string) error {
+	if len(password) < AccountPasswordMinLength {
+		return fmt.Errorf("password must be at least %d characters", AccountPasswordMinLength)
+	}
+	// bcrypt ignores anything past this
+	if len(password) > 72 {
+		return errors.New("password can't be longer than 72 bytes")
+	}
+
+	return nil
+}
+
+func (a *Accounts) Register(username string, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(Account{
+		Username:     username,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_ACCOUNTS))
+
+		key := accountKey(username)
+		if b.Get(key) != nil {
+			return ErrUsernameTaken
+		}
+
+		return b.Put(key, data)
+	})
+}
+
+func (a *Accounts) Get(username string) (*Account, error) {
+	var account *Account
+
+	err := a.DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(BUCKET_ACCOUNTS)).Get(accountKey(username))
+		if data == nil {
+			return nil
+		}
+
+		account = &Account{}
+		return json.Unmarshal(data, account)
+	})
+
+	return account, err
+}
+
+func (a *Accounts) Exists(username string) (bool, error) {
+	account, err := a.Get(username)
+	return account != nil, err
+}
+
+// Checks the password, and hands out a new session token
+func (a *Accounts) Login(username string, password string) (string, *Session, error) {
+	account, err := a.Get(username)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if account == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)) != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	session := &Session{
+		Username:  account.Username,
+		ExpiresAt: time.Now().Add(CurrentConfig().Accounts.SessionTTL).UnixMilli(),
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = a.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_SESSIONS))
+
+		// Might as well clean up while we're here
+		now := time.Now().UnixMilli()
+		expired := make([][]byte, 0)
+		b.ForEach(func(k, v []byte) error {
+			var s Session
+			if json.Unmarshal(v, &s) != nil || s.ExpiresAt <= now {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		for _, k := range expired {
+			b.Delete(k)
+		}
+
+		return b.Put(sessionKey(token), data)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, session, nil
+}
+
+// Returns the session of the token, or nil if it's unknown or has expired
+func (a *Accounts) Session(token string) (*Session, error) {
+	var session *Session
+
+	err := a.DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(BUCKET_SESSIONS)).Get(sessionKey(token))
+		if data == nil {
+			return nil
+		}
+
+		session = &Session{}
+		return json.Unmarshal(data, session)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if session != nil && session.ExpiresAt <= time.Now().UnixMilli() {
+		return nil, a.Logout(token)
+	}
+
+	return session, nil
+}
+
+func (a *Accounts) Logout(token string) error {
+	return a.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BUCKET_SESSIONS)).Delete(sessionKey(token))
+	})
+}
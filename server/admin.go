@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
+)
+
+// The bearer token required by the admin endpoints, empty if they're disabled
+var AdminToken = ""
+
+type AdminClientDetail struct {
+	ID        string      `json:"id"`
+	Username  string      `json:"username"`
+	Host      bool        `json:"host"`
+	Spectator bool        `json:"spectator"`
+	InMatch   bool        `json:"in_match"`
+	State     ClientState `json:"state"`
+	JoinOrder int         `json:"join_order"`
+
+	Reconnecting      bool  `json:"reconnecting"`
+	ReconnectDeadline int64 `json:"reconnect_deadline,omitempty"`
+
+	// Average round trip time in milliseconds
+	Latency int64 `json:"latency"`
+}
+
+type AdminRoomDetail struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Private    bool      `json:"private"`
+	Locked     bool      `json:"locked"`
+	MaxPlayers int       `json:"max_players"`
+	State      RoomState `json:"state"`
+
+	SongHash       string `json:"song_hash"`
+	SongDifficulty string `json:"song_difficulty"`
+
+	MatchStart int64 `json:"match_start"`
+	MatchEnd   int64 `json:"match_end"`
+
+	Settings protocol.RoomSettings `json:"settings"`
+	Queue    []protocol.QueueEntry `json:"queue"`
+	Vote     protocol.Vote         `json:"vote"`
+
+	Banned  []string            `json:"banned"`
+	Clients []AdminClientDetail `json:"clients"`
+}
+
+// Checks the request's bearer token against the configured admin token,
+// and writes the error response if it doesn't match
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	adminToken := CurrentConfig().Server.AdminToken
+	if adminToken == "" {
+		writeJSONStatus(w, 404, APIError{Code: "admin_disabled", Message: "admin api is disabled"})
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONStatus(w, 401, APIError{Code: "unauthorized", Message: "invalid admin token"})
+		return false
+	}
+
+	return true
+}
+
+// Authorizes the request and looks up the room from the path, writing the error response if either fails
+func adminRoom(lobby *Lobby, w http.ResponseWriter, r *http.Request) *Room {
+	if !authorizeAdmin(w, r) {
+		return nil
+	}
+
+	room := lobby.GetRoom(r.PathValue("id"))
+	if room == nil {
+		writeJSONStatus(w, 404, APIError{Code: "unknown_room", Message: "unknown room"})
+		return nil
+	}
+
+	return room
+}
+
+// Builds a snapshot of the room, must be called from within the room's goroutine
+func (r *Room) AdminDetail() AdminRoomDetail {
+	d := AdminRoomDetail{
+		ID:             r.UUID,
+		Title:          r.Title,
+		Private:        r.Private,
+		Locked:         r.IsLocked(),
+		MaxPlayers:     r.MaxPlayers,
+		State:          r.State,
+		SongHash:       r.SongHash,
+		SongDifficulty: r.SongDifficulty,
+		MatchStart:     r.MatchStart,
+		MatchEnd:       r.MatchEnd,
+		Settings:       r.Settings(),
+		Queue:          slices.Clone(r.Queue),
+		Vote:           r.VoteStatus(""),
+		Banned:         make([]string, 0, len(r.Banned)),
+		Clients:        make([]AdminClientDetail, 0, len(r.Clients)),
+	}
+
+	for _, username := range r.Banned {
+		d.Banned = append(d.Banned, username)
+	}
+
+	for c := range r.Clients {
+		d.Clients = append(d.Clients, AdminClientDetail{
+			ID:                c.UUID,
+			Username:          c.Username,
+			Host:              c.Host,
+			Spectator:         c.Spectator,
+			InMatch:           c.InMatch,
+			State:             c.State,
+			JoinOrder:         c.JoinOrder,
+			Reconnecting:      c.Reconnecting,
+			ReconnectDeadline: c.ReconnectDeadline,
+			Latency:           c.Latency(),
+		})
+	}
+
+	slices.Sort(d.Banned)
+	slices.SortFunc(d.Clients, func(a, b AdminClientDetail) int {
+		return cmp.Compare(a.JoinOrder, b.JoinOrder)
+	})
+
+	return d
+}
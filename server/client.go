@@ -4,18 +4,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
-	"git.jaezmien.com/Jaezmien/notitg-party/server/events"
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
 	"github.com/gorilla/websocket"
 )
 
 type ClientState int
 
 var ClientScoreThrottleMS = time.Duration(time.Second * 1).Milliseconds()
+var ClientChatRateLimit = 5
+var ClientChatRateWindowMS = time.Duration(time.Second * 10).Milliseconds()
 var clientWriteWait = time.Second * 10
 var clientPongWait = time.Second * 60
-var clientPingPeriod = (clientPongWait * 9) / 10
+
+// Pings double as our latency measurement, so they're sent a lot more often than clientPongWait requires
+var clientPingPeriod = time.Second * 5
+
+// How much weight a new round trip sample has on the client's average latency
+var clientLatencySmoothing = 0.2
 
 const (
 	CLIENT_IDLE ClientState = iota
@@ -35,14 +46,39 @@ type Client struct {
 	Username string
 	Host     bool
 
+	// Tags every line with the client's room, id and username
+	Logger *slog.Logger
+
+	// Spectators receive the match's data, but never play in it
+	Spectator bool
+
+	// The lower the number, the longer the client has been in the room
+	JoinOrder int
+
 	InMatch bool
 
-	Send   chan []byte
-	Closed bool
+	Send chan []byte
+	// Only set by the room, but the reader checks it as well
+	Closed atomic.Bool
+
+	// Handed out to the client, so that it can reattach itself after losing its connection
+	ResumeToken string
+
+	Reconnecting      bool
+	ReconnectDeadline int64
+
+	// Closed when the client's current connection has been detached
+	detached chan struct{}
 
 	State ClientState
 
+	// Rolling average of the client's round trip time, in milliseconds
+	latency atomic.Int64
+
 	userScoreThrottle int64
+
+	chatWindowStart int64
+	chatWindowCount int
 }
 
 // Returns false if being throttled, thus we shouldn't update
@@ -51,124 +87,242 @@ func (c *Client) UpdateScoreThrottle() bool {
 	if now < c.userScoreThrottle {
 		return false
 	}
-	c.userScoreThrottle = now + ClientScoreThrottleMS
+	c.userScoreThrottle = now + CurrentConfig().Client.ScoreThrottle.Milliseconds()
+	return true
+}
+
+// Returns false if the client has sent too many chat messages recently
+func (c *Client) UpdateChatRateLimit() bool {
+	cfg := CurrentConfig()
+	now := time.Now().UnixMilli()
+	if now >= c.chatWindowStart+cfg.Client.ChatRateWindow.Milliseconds() {
+		c.chatWindowStart = now
+		c.chatWindowCount = 0
+	}
+	if c.chatWindowCount >= cfg.Client.ChatRateLimit {
+		return false
+	}
+	c.chatWindowCount++
 	return true
 }
 
 func (c *Client) Close() {
-	if c.Closed {
+	if c.Closed.Load() {
 		return
 	}
 
-	logger.Info("closing client", slog.String("id", c.UUID))
+	c.Logger.Info("closing client")
 
-	c.Closed = true
-	c.Connection.Close()
+	// The writer sends whatever is left in the channel before closing the connection
+	c.Closed.Store(true)
 	close(c.Send)
+
+	// ...unless we don't have a writer anymore
+	if c.Reconnecting {
+		c.Connection.Close()
+	}
+}
+
+// Detaches the client's lost connection, and waits for it to reconnect
+func (c *Client) Detach() {
+	c.Reconnecting = true
+	c.ReconnectDeadline = time.Now().UnixMilli() + CurrentConfig().Room.ResumeGrace.Milliseconds()
+
+	close(c.detached)
+	c.Connection.Close()
+}
+
+// Attaches a new connection to the client
+func (c *Client) Attach(conn *websocket.Conn) {
+	c.Connection = conn
+	c.Reconnecting = false
+	c.ReconnectDeadline = 0
+	c.detached = make(chan struct{})
+
+	go c.Write()
+	go c.Read()
+}
+
+// Returns the client's average round trip time in milliseconds, or 0 if it hasn't been measured yet
+func (c *Client) Latency() int64 {
+	return c.latency.Load()
+}
+
+func (c *Client) UpdateLatency(rtt int64) {
+	if rtt < 0 {
+		return
+	}
+
+	// Make sure a measured latency never reads as unmeasured
+	rtt = max(rtt, 1)
+
+	avg := c.latency.Load()
+	if avg == 0 {
+		c.latency.Store(rtt)
+		return
+	}
+
+	c.latency.Store(avg + int64(float64(rtt-avg)*clientLatencySmoothing))
 }
 
 func (c *Client) SetNewState(state ClientState) {
 	c.State = state
-	c.Room.BroadcastAll(events.NewUserStateEvent(c.UUID, int(state)))
+	c.Room.BroadcastAll(protocol.NewUserStateEvent(c.UUID, int(state)))
+}
+
+// Lets both us and the client know that it has sent something we couldn't make sense of.
+// Must be called from the client's reader, use the room's InvalidEvent from within the room.
+func (c *Client) InvalidEvent(t protocol.EventType, err error) {
+	c.Room.Exec(func() { c.Room.InvalidEvent(c, t, err) })
 }
 
 func (c *Client) Write() {
+	// Hold on to the connection we've started with, as it can be replaced when the client resumes
+	conn := c.Connection
+	detached := c.detached
+
 	ticker := time.NewTicker(clientPingPeriod)
 	defer func() {
 		ticker.Stop()
-		c.Close()
+		conn.Close()
 	}()
 
 	for {
 		select {
+		case <-detached:
+			return
 		case message, ok := <-c.Send:
-			c.Connection.SetWriteDeadline(time.Now().Add(clientWriteWait))
+			conn.SetWriteDeadline(time.Now().Add(CurrentConfig().Client.WriteWait))
 			if !ok {
-				c.Connection.WriteMessage(websocket.CloseMessage, []byte{})
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 				return
 			}
 
-			w, err := c.Connection.NextWriter(websocket.TextMessage)
+			w, err := conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
 			}
 
-			logger.Debug("sending data to client", slog.String("id", c.UUID), slog.String("data", string(message)))
+			c.Logger.Debug("sending data to client", slog.String("data", string(message)))
 			w.Write(message)
 
 			if err := w.Close(); err != nil {
 				return
 			}
 		case <-ticker.C:
-			c.Connection.SetWriteDeadline(time.Now().Add(clientWriteWait))
-			if err := c.Connection.WriteMessage(websocket.PingMessage, nil); err != nil {
+			conn.SetWriteDeadline(time.Now().Add(CurrentConfig().Client.WriteWait))
+			// The client echoes this back in its pong, which lets us measure the round trip
+			sent := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			if err := conn.WriteMessage(websocket.PingMessage, []byte(sent)); err != nil {
 				return
 			}
 		}
 	}
 }
 func (c *Client) Read() {
-	c.Connection.SetReadDeadline(time.Now().Add(clientPongWait))
-	c.Connection.SetPongHandler(func(appData string) error {
-		c.Connection.SetReadDeadline(time.Now().Add(clientPongWait))
+	// Hold on to the connection we've started with, as it can be replaced when the client resumes
+	conn := c.Connection
+	detached := c.detached
+
+	conn.SetReadDeadline(time.Now().Add(CurrentConfig().Client.PongWait))
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(CurrentConfig().Client.PongWait))
+
+		if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			c.UpdateLatency(time.Now().UnixMilli() - sent)
+		}
 		return nil
 	})
 
 	for {
-		t, message, err := c.Connection.ReadMessage()
+		t, message, err := conn.ReadMessage()
+		received := time.Now().UnixMilli()
 
-		if c.Closed {
+		if c.Closed.Load() {
 			return
 		}
 		if err != nil {
-			logger.Error("error while reading client message", slog.Any("err", err))
+			// Anything other than a proper close means that we've lost the client,
+			// so give them a chance to come back.
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				select {
+				case <-detached:
+					// The client has already resumed on a new connection
+					return
+				default:
+				}
+
+				c.Logger.Warn("lost connection to client", slog.Any("err", err))
+				select {
+				case c.Room.Disconnect <- c:
+				case <-c.Room.Quit:
+				}
+				return
+			}
+
+			c.Logger.Error("error while reading client message", slog.Any("err", err))
 			break
 		}
 
 		if t != websocket.TextMessage {
-			logger.Error("unknown client message (non-text message)")
+			c.Logger.Error("unknown client message (non-text message)")
 			break
 		}
 
-		var event events.RawEvent
+		var event protocol.RawEvent
 		if err := json.Unmarshal(message, &event); err != nil {
-			logger.Debug("error while parsing client message", slog.Any("err", err))
-			break
+			c.InvalidEvent("", err)
+			continue
 		}
 
-		if event.Type != events.EVENT_USER_SCORE {
-			logger.Debug(fmt.Sprintf("received event: %s", event.Type))
+		if event.Type != protocol.EVENT_USER_SCORE {
+			c.Logger.Debug("received event", slog.String("event", string(event.Type)))
 		}
 
 		switch event.Type {
-		case events.EVENT_ROOM_SONG:
-			data, err := events.ParseRoomSongEvent(event.Data)
+		case protocol.EVENT_ROOM_SONG:
+			data, err := protocol.ParseRoomSongEvent(event.Data)
 			if err != nil {
-				logger.Debug("invalid client data", slog.Any("err", err))
+				c.InvalidEvent(event.Type, err)
 				break
 			}
 
 			if !c.Room.IsIdle() {
-				logger.Debug("room not in lobby, ignoring song change")
+				c.Logger.Debug("room not in lobby, ignoring song change")
 				break
 			}
 			if !c.Host {
-				logger.Debug("client is not host, ignoring")
+				c.Logger.Debug("client is not host, ignoring")
 				break
 			}
 
-			logger.Info("changing song!")
-			c.Room.SetSong(data.Hash, data.Difficulty)
-		case events.EVENT_USER_SONG_STATE:
-			data, err := events.ParseUserSongStateEvent(event.Data)
+			// The vote can pick a song of its own at any moment
+			c.Room.Exec(func() {
+				if c.Room.IsVoting() {
+					c.Logger.Debug("room is voting on the next song, ignoring")
+					return
+				}
+				if !c.Room.AllowsDifficulty(data.Difficulty) {
+					c.Room.InvalidEvent(c, event.Type, fmt.Errorf("difficulty is outside of the room's range"))
+					return
+				}
+
+				c.Logger.Info("changing song!")
+				c.Room.SetSong(data.Hash, data.Difficulty)
+			})
+		case protocol.EVENT_USER_SONG_STATE:
+			data, err := protocol.ParseUserSongStateEvent(event.Data)
 			if err != nil {
-				logger.Debug("invalid client data", slog.Any("err", err))
+				c.InvalidEvent(event.Type, err)
 				break
 			}
 
 			if !c.Room.IsIdle() {
 				break
 			}
+			if c.Spectator {
+				break
+			}
 
 			if data.HasSong {
 				c.SetNewState(CLIENT_IDLE)
@@ -176,15 +330,16 @@ func (c *Client) Read() {
 				c.SetNewState(CLIENT_MISSING_SONG)
 			}
 
-			c.Room.BroadcastAll(events.NewUserStateEvent(c.UUID, int(c.State)))
-		case events.EVENT_USER_STATE:
-			data, err := events.ParseUserStateEvent(event.Data)
+			c.Room.BroadcastAll(protocol.NewUserStateEvent(c.UUID, int(c.State)))
+			c.Room.TryAutoStart()
+		case protocol.EVENT_USER_STATE:
+			data, err := protocol.ParseUserStateEvent(event.Data)
 			if err != nil {
-				logger.Debug("invalid client data", slog.Any("err", err))
+				c.InvalidEvent(event.Type, err)
 				break
 			}
 
-			if c.State == CLIENT_MISSING_SONG {
+			if c.Spectator || c.State == CLIENT_MISSING_SONG {
 				break
 			}
 
@@ -193,13 +348,204 @@ func (c *Client) Read() {
 			} else {
 				c.SetNewState(CLIENT_LOBBY_READY)
 			}
-		case events.EVENT_ROOM_START:
+
+			c.Room.TryAutoStart()
+		case protocol.EVENT_ROOM_CAPACITY:
+			data, err := protocol.ParseRoomCapacityEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			if !c.Host {
+				c.Logger.Debug("client is not host, ignoring")
+				break
+			}
+
+			// Same as changing the room's settings, with everything else left as is
+			c.Room.Exec(func() {
+				settings := c.Room.Settings()
+				settings.MaxPlayers = data.MaxPlayers
+
+				if err := c.Room.ValidateSettings(settings); err != nil {
+					c.Room.InvalidEvent(c, event.Type, err)
+					return
+				}
+
+				c.Room.SetSettings(settings)
+			})
+		case protocol.EVENT_ROOM_SETTINGS:
+			data, err := protocol.ParseRoomSettingsEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			if !c.Host {
+				c.Logger.Debug("client is not host, ignoring")
+				break
+			}
+			c.Room.Exec(func() {
+				if err := c.Room.ValidateSettings(data); err != nil {
+					c.Room.InvalidEvent(c, event.Type, err)
+					return
+				}
+
+				c.Room.SetSettings(data)
+			})
+		case protocol.EVENT_QUEUE_ADD:
+			data, err := protocol.ParseQueueAddEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			c.Room.Exec(func() {
+				if !c.Room.CanEditQueue(c) {
+					c.Logger.Debug("client can't edit the queue, ignoring")
+					return
+				}
+
+				if err := c.Room.AddToQueue(c, data.Hash, data.Difficulty); err != nil {
+					c.Room.InvalidEvent(c, event.Type, err)
+				}
+			})
+		case protocol.EVENT_QUEUE_REMOVE:
+			data, err := protocol.ParseQueueRemoveEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			c.Room.Exec(func() {
+				if !c.Room.CanEditQueue(c) {
+					c.Logger.Debug("client can't edit the queue, ignoring")
+					return
+				}
+
+				c.Room.RemoveFromQueue(data.ID)
+			})
+		case protocol.EVENT_QUEUE_MOVE:
+			data, err := protocol.ParseQueueMoveEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			c.Room.Exec(func() {
+				if !c.Room.CanEditQueue(c) {
+					c.Logger.Debug("client can't edit the queue, ignoring")
+					return
+				}
+
+				c.Room.MoveInQueue(data.ID, data.Index)
+			})
+		case protocol.EVENT_VOTE_NOMINATE:
+			data, err := protocol.ParseNominateEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			c.Room.Exec(func() {
+				if err := c.Room.Nominate(c, data.Hash, data.Difficulty); err != nil {
+					c.Room.InvalidEvent(c, event.Type, err)
+				}
+			})
+		case protocol.EVENT_VOTE_CAST:
+			data, err := protocol.ParseCastVoteEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			c.Room.Exec(func() {
+				if err := c.Room.CastVote(c, data.ID); err != nil {
+					c.Room.InvalidEvent(c, event.Type, err)
+				}
+			})
+		case protocol.EVENT_USER_KICK, protocol.EVENT_USER_BAN:
+			data, err := protocol.ParseModerationEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			if !c.Host {
+				c.Logger.Debug("client is not host, ignoring")
+				break
+			}
+
+			ban := event.Type == protocol.EVENT_USER_BAN
+			c.Room.Exec(func() {
+				target := c.Room.GetClientFromID(data.ID)
+				if target == nil || target == c {
+					return
+				}
+
+				c.Room.KickClient(target, data.Reason, ban)
+			})
+		case protocol.EVENT_HOST_TRANSFER:
+			data, err := protocol.ParseHostTransferEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			c.Room.Exec(func() {
+				if !c.Host {
+					c.Logger.Debug("client is not host, ignoring")
+					return
+				}
+
+				target := c.Room.GetClientFromID(data.ID)
+				if target == nil || target == c || target.Spectator {
+					return
+				}
+
+				c.Room.TransferHost(target)
+			})
+		case protocol.EVENT_ROOM_CHAT:
+			data, err := protocol.ParseSendChatEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			message := strings.TrimSpace(data.Message)
+			if message == "" {
+				break
+			}
+			if maxLength := CurrentConfig().Room.ChatMaxLength; utf8.RuneCountInString(message) > maxLength {
+				c.Room.Exec(func() {
+					c.Room.SendTo(c, protocol.NewChatRejectedEvent(fmt.Sprintf("message is longer than %d characters", maxLength)))
+				})
+				break
+			}
+			if !c.UpdateChatRateLimit() {
+				c.Room.Exec(func() { c.Room.SendTo(c, protocol.NewChatRejectedEvent("you are sending messages too quickly")) })
+				break
+			}
+
+			// The backlog belongs to the room, so it has to be kept up to date from there
+			c.Room.Exec(func() { c.Room.SendChat(c, CensorText(message)) })
+		case protocol.EVENT_CLOCK_PING:
+			data, err := protocol.ParseClockPingEvent(event.Data)
+			if err != nil {
+				c.InvalidEvent(event.Type, err)
+				break
+			}
+
+			c.Room.Exec(func() {
+				c.Room.SendTo(c, protocol.NewClockPongEvent(data.ClientTime, received, time.Now().UnixMilli()))
+			})
+		case protocol.EVENT_ROOM_START:
 			if !c.Host {
 				break
 			}
 
 			c.Room.ReadyMatch()
-		case events.EVENT_USER_READY:
+		case protocol.EVENT_USER_READY:
 			if c.State != CLIENT_GAME_LOADING {
 				break
 			}
@@ -207,7 +553,9 @@ func (c *Client) Read() {
 			c.SetNewState(CLIENT_GAME_READY)
 
 			c.Room.StartMatch(false)
-		case events.EVENT_USER_SCORE:
+		case protocol.EVENT_USER_SCORE:
+			metrics.ScoresReceived.Add(1)
+
 			if !c.InMatch {
 				break
 			}
@@ -217,23 +565,31 @@ func (c *Client) Read() {
 
 			// Throttle client score events (considering that we're constantly broadcasting this)
 			if !c.UpdateScoreThrottle() {
+				metrics.ScoresThrottled.Add(1)
 				break
 			}
 
-			data, err := events.ParseGameplayScoreEvent(event.Data)
+			data, err := protocol.ParseSubmitScoreEvent(event.Data)
 			if err != nil {
-				logger.Debug("invalid client data", slog.Any("err", err))
+				c.InvalidEvent(event.Type, err)
 				break
 			}
 
-			c.Room.ForClientInMatch(func(cl *Client) {
-				if cl.UUID == c.UUID {
-					return
+			// The match can be finished and thrown away by the room at any moment
+			c.Room.Exec(func() {
+				if c.Room.Match != nil {
+					c.Room.Match.UpdateScore(c.UUID, data.Score)
 				}
 
-				cl.Send <- events.NewGameplayScoreEvent(c.UUID, data.Score)
+				c.Room.ForClientWatching(func(cl *Client) {
+					if cl.UUID == c.UUID {
+						return
+					}
+
+					cl.Send <- protocol.NewGameplayScoreEvent(c.UUID, data.Score)
+				})
 			})
-		case events.EVENT_USER_FINISH:
+		case protocol.EVENT_USER_FINISH:
 			if !c.InMatch {
 				break
 			}
@@ -241,42 +597,40 @@ func (c *Client) Read() {
 				break
 			}
 
-			data, err := events.ParseGameplayFinishEvent(event.Data)
+			data, err := protocol.ParseSubmitFinishEvent(event.Data)
 			if err != nil {
-				logger.Debug("invalid client data", slog.Any("err", err))
+				c.InvalidEvent(event.Type, err)
 				break
 			}
 
-			c.Room.ForClientInMatch(func(cl *Client) {
-				if cl.UUID == c.UUID {
-					return
-				}
+			c.Room.Exec(func() {
+				c.Room.ForClientWatching(func(cl *Client) {
+					if cl.UUID == c.UUID {
+						return
+					}
 
-				cl.Send <- events.NewGameplayFinishEvent(
-					c.UUID,
-					data.Score, events.JudgmentScore{
-						Marvelous: data.Marvelous,
-						Perfect:   data.Perfect,
-						Great:     data.Great,
-						Good:      data.Good,
-						Boo:       data.Boo,
-						Miss:      data.Miss,
-					},
-				)
-			})
+					cl.Send <- protocol.NewGameplayFinishEvent(c.UUID, data.Score, data.Judgments)
+				})
 
-			c.SetNewState(CLIENT_RESULTS)
-			c.Room.BroadcastAll(events.NewRoomStateEvent(int(CLIENT_RESULTS)))
+				if c.Room.Match != nil {
+					c.Room.Match.SetResult(c.UUID, data)
+				}
 
-			// We're the host, we're the source of truth.
-			// If we have finished, then we can tell the server that the end time has been reached.
-			if c.Host {
-				c.Room.UpdateExpectedMatchEnd()
-			}
+				c.SetNewState(CLIENT_RESULTS)
+				c.Room.BroadcastAll(protocol.NewRoomStateEvent(int(CLIENT_RESULTS)))
 
-			logger.Info("player has finished song", slog.String("id", c.Room.UUID))
+				// We're the host, we're the source of truth.
+				// If we have finished, then we can tell the server that the end time has been reached.
+				if c.Host {
+					c.Room.UpdateExpectedMatchEnd()
+				}
 
-			c.Room.FinishMatch(false)
+				c.Logger.Info("player has finished song")
+
+				c.Room.FinishMatch(false)
+			})
+		default:
+			c.InvalidEvent(event.Type, fmt.Errorf("unknown event"))
 		}
 	}
 
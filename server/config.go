@@ -0,0 +1,444 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+var ConfigPath = "config.ini"
+
+type ServerConfig struct {
+	Port          int           `ini:"port"`
+	Verbose       bool          `ini:"verbose"`
+	History       string        `ini:"history"`
+	ShutdownGrace time.Duration `ini:"shutdown_grace"`
+	AdminToken    string        `ini:"admin_token"`
+
+	TLSCert         string `ini:"tls_cert"`
+	TLSKey          string `ini:"tls_key"`
+	TLSRedirectPort int    `ini:"tls_redirect_port"`
+}
+
+type RoomConfig struct {
+	StartGrace        time.Duration `ini:"start_grace"`
+	EndGrace          time.Duration `ini:"end_grace"`
+	ResumeGrace       time.Duration `ini:"resume_grace"`
+	StartCountdown    time.Duration `ini:"start_countdown"`
+	TickInterval      time.Duration `ini:"tick_interval"`
+	DefaultMaxPlayers int           `ini:"default_max_players"`
+	ChatMaxLength     int           `ini:"chat_max_length"`
+	ChatBacklog       int           `ini:"chat_backlog"`
+	QueueMaxLength    int           `ini:"queue_max_length"`
+	NominationTime    time.Duration `ini:"nomination_time"`
+	VoteTime          time.Duration `ini:"vote_time"`
+}
+
+type ClientConfig struct {
+	ScoreThrottle  time.Duration `ini:"score_throttle"`
+	WriteWait      time.Duration `ini:"write_wait"`
+	PongWait       time.Duration `ini:"pong_wait"`
+	PingPeriod     time.Duration `ini:"ping_period"`
+	ChatRateLimit  int           `ini:"chat_rate_limit"`
+	ChatRateWindow time.Duration `ini:"chat_rate_window"`
+}
+
+type LimitsConfig struct {
+	// The highest max_players value a room can have, 0 if unlimited
+	MaxPlayers int `ini:"max_players"`
+
+	MaxRooms         int           `ini:"max_rooms"`
+	EmptyRoomTimeout time.Duration `ini:"empty_room_timeout"`
+
+	// Requests allowed per minute for each IP, 0 if unlimited
+	CreatePerMinute int `ini:"create_per_minute"`
+	CreateBurst     int `ini:"create_burst"`
+	JoinPerMinute   int `ini:"join_per_minute"`
+	JoinBurst       int `ini:"join_burst"`
+	AuthPerMinute   int `ini:"auth_per_minute"`
+	AuthBurst       int `ini:"auth_burst"`
+
+	// Comma separated IPs and CIDR ranges whose X-Forwarded-For header is trusted
+	TrustedProxies string `ini:"trusted_proxies"`
+}
+
+type AccountsConfig struct {
+	Path         string        `ini:"path"`
+	SessionTTL   time.Duration `ini:"session_ttl"`
+	RequireLogin bool          `ini:"require_login"`
+}
+
+type ModerationConfig struct {
+	UsernameMinLength int    `ini:"username_min_length"`
+	UsernameMaxLength int    `ini:"username_max_length"`
+	TitleMaxLength    int    `ini:"title_max_length"`
+	Words             string `ini:"words"`
+	WordsFile         string `ini:"words_file"`
+}
+
+type LogConfig struct {
+	Format     string `ini:"format"`
+	File       string `ini:"file"`
+	MaxSizeMB  int    `ini:"max_size_mb"`
+	MaxBackups int    `ini:"max_backups"`
+}
+
+type Config struct {
+	Server     ServerConfig     `ini:"server"`
+	Room       RoomConfig       `ini:"room"`
+	Client     ClientConfig     `ini:"client"`
+	Limits     LimitsConfig     `ini:"limits"`
+	Accounts   AccountsConfig   `ini:"accounts"`
+	Moderation ModerationConfig `ini:"moderation"`
+	Log        LogConfig        `ini:"log"`
+}
+
+// The configuration that the server is running with. Reloading replaces it as a whole instead of
+// changing it in place, so the rooms and handlers reading it always see one complete set of values.
+var liveConfig atomic.Pointer[Config]
+
+// Returns the configuration that the server is currently running with,
+// or the defaults if it hasn't been applied yet
+func CurrentConfig() *Config {
+	if c := liveConfig.Load(); c != nil {
+		return c
+	}
+
+	c := DefaultConfig()
+	return &c
+}
+
+// Returns the built in defaults, along with whatever was passed through the flags.
+// The package variables only hold these defaults, the running values are read through CurrentConfig.
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:          Port,
+			Verbose:       Verbose,
+			History:       HistoryPath,
+			ShutdownGrace: ShutdownGracePeriod,
+			AdminToken:    AdminToken,
+
+			TLSCert:         TLSCertPath,
+			TLSKey:          TLSKeyPath,
+			TLSRedirectPort: TLSRedirectPort,
+		},
+		Room: RoomConfig{
+			StartGrace:        time.Duration(RoomStartGracePeriod) * time.Millisecond,
+			EndGrace:          time.Duration(RoomEndGracePeriod) * time.Millisecond,
+			ResumeGrace:       time.Duration(RoomResumeGracePeriod) * time.Millisecond,
+			StartCountdown:    time.Duration(RoomStartCountdown) * time.Millisecond,
+			TickInterval:      RoomTickInterval,
+			DefaultMaxPlayers: RoomDefaultMaxPlayers,
+			ChatMaxLength:     RoomChatMaxLength,
+			ChatBacklog:       RoomChatBacklogSize,
+			QueueMaxLength:    RoomQueueMaxLength,
+			NominationTime:    RoomNominationDuration,
+			VoteTime:          RoomVoteDuration,
+		},
+		Client: ClientConfig{
+			ScoreThrottle:  time.Duration(ClientScoreThrottleMS) * time.Millisecond,
+			WriteWait:      clientWriteWait,
+			PongWait:       clientPongWait,
+			PingPeriod:     clientPingPeriod,
+			ChatRateLimit:  ClientChatRateLimit,
+			ChatRateWindow: time.Duration(ClientChatRateWindowMS) * time.Millisecond,
+		},
+		Limits: LimitsConfig{
+			MaxPlayers:       RoomMaxPlayersLimit,
+			MaxRooms:         LobbyMaxRooms,
+			EmptyRoomTimeout: time.Duration(RoomEmptyTimeout) * time.Millisecond,
+			CreatePerMinute:  CreatePerMinute,
+			CreateBurst:      CreateBurst,
+			JoinPerMinute:    JoinPerMinute,
+			JoinBurst:        JoinBurst,
+			AuthPerMinute:    AuthPerMinute,
+			AuthBurst:        AuthBurst,
+			TrustedProxies:   TrustedProxiesValue,
+		},
+		Accounts: AccountsConfig{
+			Path:         AccountsPath,
+			SessionTTL:   AccountSessionTTL,
+			RequireLogin: AccountsRequireLogin,
+		},
+		Moderation: ModerationConfig{
+			UsernameMinLength: UsernameMinLength,
+			UsernameMaxLength: UsernameMaxLength,
+			TitleMaxLength:    RoomTitleMaxLength,
+			Words:             FilterWords,
+			WordsFile:         FilterWordsFile,
+		},
+		Log: LogConfig{
+			Format:     LogFormat,
+			File:       LogFile,
+			MaxSizeMB:  LogMaxSizeMB,
+			MaxBackups: LogMaxBackups,
+		},
+	}
+}
+
+// Reads the config file on top of the current configuration, followed by the PARTY_<SECTION>_<KEY>
+// environment variables. Flags that were explicitly passed always win.
+func LoadConfig(path string, required bool) (Config, error) {
+	cfg := *CurrentConfig()
+
+	file := ini.Empty()
+	if path != "" {
+		f, err := ini.Load(path)
+		if err != nil {
+			if required || !errors.Is(err, os.ErrNotExist) {
+				return cfg, fmt.Errorf("config: %w", err)
+			}
+		} else {
+			file = f
+		}
+	}
+
+	var errs []error
+
+	// XXX: ini's own struct mapping silently skips durations that aren't positive, so we do it ourselves
+	known := make(map[string]bool)
+	v := reflect.ValueOf(&cfg).Elem()
+	for i := range v.NumField() {
+		section := v.Type().Field(i).Tag.Get("ini")
+		for j := range v.Field(i).NumField() {
+			key := v.Field(i).Type().Field(j).Tag.Get("ini")
+			known[section+"."+key] = true
+
+			// Environment variables override the values in the file
+			env := "PARTY_" + strings.ToUpper(section) + "_" + strings.ToUpper(key)
+			value, ok := os.LookupEnv(env)
+			if !ok {
+				if !file.Section(section).HasKey(key) {
+					continue
+				}
+				value = file.Section(section).Key(key).String()
+			}
+
+			if err := setConfigValue(v.Field(i).Field(j), value); err != nil {
+				errs = append(errs, fmt.Errorf("[%s] %s: %w", section, key, err))
+			}
+		}
+	}
+
+	for _, section := range file.Sections() {
+		for _, key := range section.Keys() {
+			if !known[section.Name()+"."+key.Name()] {
+				errs = append(errs, fmt.Errorf("[%s] %s: unknown setting", section.Name(), key.Name()))
+			}
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = Port
+		case "verbose":
+			cfg.Server.Verbose = Verbose
+		case "history":
+			cfg.Server.History = HistoryPath
+		case "accounts":
+			cfg.Accounts.Path = AccountsPath
+		case "shutdown-grace":
+			cfg.Server.ShutdownGrace = ShutdownGracePeriod
+		case "log-format":
+			cfg.Log.Format = LogFormat
+		case "log-file":
+			cfg.Log.File = LogFile
+		case "tls-cert":
+			cfg.Server.TLSCert = TLSCertPath
+		case "tls-key":
+			cfg.Server.TLSKey = TLSKeyPath
+		case "tls-redirect-port":
+			cfg.Server.TLSRedirectPort = TLSRedirectPort
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+func setConfigValue(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case string:
+		field.SetString(value)
+	default:
+		panic(fmt.Errorf("unsupported config type %s", field.Type()))
+	}
+
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, a ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, a...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "[server] port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.ShutdownGrace >= 0, "[server] shutdown_grace must not be negative")
+	check((c.Server.TLSCert == "") == (c.Server.TLSKey == ""), "[server] tls_cert and tls_key must be set together")
+	if c.Server.TLSRedirectPort != 0 {
+		check(c.Server.TLSCert != "", "[server] tls_redirect_port requires tls_cert and tls_key")
+		check(c.Server.TLSRedirectPort > 0 && c.Server.TLSRedirectPort <= 65535, "[server] tls_redirect_port must be between 1 and 65535, got %d", c.Server.TLSRedirectPort)
+		check(c.Server.TLSRedirectPort != c.Server.Port, "[server] tls_redirect_port must differ from port")
+	}
+
+	check(c.Room.StartGrace > 0, "[room] start_grace must be positive")
+	check(c.Room.EndGrace > 0, "[room] end_grace must be positive")
+	check(c.Room.ResumeGrace >= 0, "[room] resume_grace must not be negative")
+	check(c.Room.StartCountdown >= 0, "[room] start_countdown must not be negative")
+	check(c.Room.TickInterval >= time.Second, "[room] tick_interval must be at least 1s")
+	check(c.Room.DefaultMaxPlayers >= 0, "[room] default_max_players must not be negative")
+	check(c.Room.ChatMaxLength > 0, "[room] chat_max_length must be positive")
+	check(c.Room.ChatBacklog >= 0, "[room] chat_backlog must not be negative")
+	check(c.Room.QueueMaxLength > 0, "[room] queue_max_length must be positive")
+	check(c.Room.NominationTime > 0, "[room] nomination_time must be positive")
+	check(c.Room.VoteTime > 0, "[room] vote_time must be positive")
+
+	check(c.Client.ScoreThrottle >= 0, "[client] score_throttle must not be negative")
+	check(c.Client.WriteWait > 0, "[client] write_wait must be positive")
+	check(c.Client.PongWait > 0, "[client] pong_wait must be positive")
+	check(c.Client.PingPeriod > 0 && c.Client.PingPeriod < c.Client.PongWait, "[client] ping_period must be positive and shorter than pong_wait (%s)", c.Client.PongWait)
+	check(c.Client.ChatRateLimit > 0, "[client] chat_rate_limit must be positive")
+	check(c.Client.ChatRateWindow > 0, "[client] chat_rate_window must be positive")
+
+	check(c.Limits.MaxPlayers >= 0, "[limits] max_players must not be negative")
+	if c.Limits.MaxPlayers > 0 {
+		check(c.Room.DefaultMaxPlayers > 0 && c.Room.DefaultMaxPlayers <= c.Limits.MaxPlayers, "[room] default_max_players must be between 1 and [limits] max_players (%d)", c.Limits.MaxPlayers)
+	}
+	check(c.Limits.MaxRooms >= 0, "[limits] max_rooms must not be negative")
+	check(c.Limits.EmptyRoomTimeout >= 0, "[limits] empty_room_timeout must not be negative")
+	check(c.Limits.CreatePerMinute >= 0, "[limits] create_per_minute must not be negative")
+	check(c.Limits.CreatePerMinute == 0 || c.Limits.CreateBurst > 0, "[limits] create_burst must be positive")
+	check(c.Limits.JoinPerMinute >= 0, "[limits] join_per_minute must not be negative")
+	check(c.Limits.JoinPerMinute == 0 || c.Limits.JoinBurst > 0, "[limits] join_burst must be positive")
+	check(c.Limits.AuthPerMinute >= 0, "[limits] auth_per_minute must not be negative")
+	check(c.Limits.AuthPerMinute == 0 || c.Limits.AuthBurst > 0, "[limits] auth_burst must be positive")
+	if _, err := ParseTrustedProxies(c.Limits.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("[limits] trusted_proxies: %w", err))
+	}
+
+	check(c.Accounts.SessionTTL > 0, "[accounts] session_ttl must be positive")
+	check(c.Accounts.Path != "" || !c.Accounts.RequireLogin, "[accounts] require_login needs accounts to be enabled")
+
+	check(c.Moderation.UsernameMinLength > 0, "[moderation] username_min_length must be positive")
+	check(c.Moderation.UsernameMaxLength >= c.Moderation.UsernameMinLength, "[moderation] username_max_length must be at least username_min_length (%d)", c.Moderation.UsernameMinLength)
+	check(c.Moderation.TitleMaxLength > 0, "[moderation] title_max_length must be positive")
+	if _, err := LoadFilterWords(c.Moderation.Words, c.Moderation.WordsFile); err != nil {
+		errs = append(errs, fmt.Errorf("[moderation] %w", err))
+	}
+
+	check(c.Log.Format == "text" || c.Log.Format == "json", "[log] format must be text or json, got %q", c.Log.Format)
+	check(c.Log.MaxSizeMB >= 0, "[log] max_size_mb must not be negative")
+	check(c.Log.MaxBackups >= 0, "[log] max_backups must not be negative")
+
+	return errors.Join(errs...)
+}
+
+// Applies the configuration. When reloading, values that can't change while the server is running are left alone.
+func (c Config) Apply(reload bool) {
+	if !reload {
+		Port = c.Server.Port
+		HistoryPath = c.Server.History
+		AccountsPath = c.Accounts.Path
+		RoomTickInterval = c.Room.TickInterval
+		clientPingPeriod = c.Client.PingPeriod
+
+		TLSCertPath = c.Server.TLSCert
+		TLSKeyPath = c.Server.TLSKey
+		TLSRedirectPort = c.Server.TLSRedirectPort
+
+		LogFormat = c.Log.Format
+		LogFile = c.Log.File
+		LogMaxSizeMB = c.Log.MaxSizeMB
+		LogMaxBackups = c.Log.MaxBackups
+	} else {
+		current := CurrentConfig()
+		if c.Server.Port != current.Server.Port ||
+			c.Server.History != current.Server.History ||
+			c.Accounts.Path != current.Accounts.Path ||
+			c.Room.TickInterval != current.Room.TickInterval ||
+			c.Client.PingPeriod != current.Client.PingPeriod ||
+			c.Server.TLSCert != current.Server.TLSCert ||
+			c.Server.TLSKey != current.Server.TLSKey ||
+			c.Server.TLSRedirectPort != current.Server.TLSRedirectPort ||
+			c.Log != current.Log {
+			logger.Warn("port, history, the accounts path, tick_interval, ping_period, the tls settings and the [log] section only take effect after a restart")
+		}
+
+		// Keep reporting what we're actually running with
+		c.Server.Port = current.Server.Port
+		c.Server.History = current.Server.History
+		c.Accounts.Path = current.Accounts.Path
+		c.Room.TickInterval = current.Room.TickInterval
+		c.Client.PingPeriod = current.Client.PingPeriod
+		c.Server.TLSCert = current.Server.TLSCert
+		c.Server.TLSKey = current.Server.TLSKey
+		c.Server.TLSRedirectPort = current.Server.TLSRedirectPort
+		c.Log = current.Log
+	}
+
+	if c.Server.Verbose {
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(slog.LevelInfo)
+	}
+
+	createLimiter.SetLimit(c.Limits.CreatePerMinute, c.Limits.CreateBurst)
+	joinLimiter.SetLimit(c.Limits.JoinPerMinute, c.Limits.JoinBurst)
+	authLimiter.SetLimit(c.Limits.AuthPerMinute, c.Limits.AuthBurst)
+
+	if proxies, err := ParseTrustedProxies(c.Limits.TrustedProxies); err == nil {
+		trustedProxies.Store(&proxies)
+	}
+	if words, err := LoadFilterWords(c.Moderation.Words, c.Moderation.WordsFile); err == nil {
+		SetFilterWords(words)
+	}
+
+	liveConfig.Store(&c)
+}
+
+// Re-reads the config file, applying whatever can be safely changed
+func ReloadConfig() {
+	cfg, err := LoadConfig(ConfigPath, false)
+	if err != nil {
+		logger.Error("could not reload config, keeping the current one", slog.Any("err", err))
+		return
+	}
+
+	cfg.Apply(true)
+	logger.Info("config has been reloaded", slog.String("path", ConfigPath))
+}
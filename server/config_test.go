@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// Runs the test with the changed configuration, putting the previous one back once it's done
+func useConfig(t *testing.T, change func(c *Config)) {
+	t.Helper()
+
+	previous := liveConfig.Load()
+	words := filteredWords.Load()
+	t.Cleanup(func() {
+		liveConfig.Store(previous)
+		filteredWords.Store(words)
+	})
+
+	c := *CurrentConfig()
+	change(&c)
+	liveConfig.Store(&c)
+}
+
+func TestApplyReload(t *testing.T) {
+	useConfig(t, func(c *Config) {})
+
+	running := *CurrentConfig()
+
+	c := running
+	c.Server.Port = running.Server.Port + 1
+	c.Room.ChatMaxLength = running.Room.ChatMaxLength + 1
+	c.Apply(true)
+
+	got := CurrentConfig()
+	if got.Server.Port != running.Server.Port {
+		t.Fatalf("port = %d, want %d (only takes effect after a restart)", got.Server.Port, running.Server.Port)
+	}
+	if got.Room.ChatMaxLength != c.Room.ChatMaxLength {
+		t.Fatalf("chat max length = %d, want %d", got.Room.ChatMaxLength, c.Room.ChatMaxLength)
+	}
+}
@@ -6,4 +6,16 @@ require github.com/gorilla/websocket v1.5.3
 
 require github.com/google/uuid v1.6.0
 
-require github.com/sio/coolname v0.1.0 // indirect
+require (
+	github.com/sio/coolname v0.1.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.40.0
+	golang.org/x/text v0.27.0
+	gopkg.in/ini.v1 v1.67.0
+)
+
+require golang.org/x/sys v0.34.0 // indirect
+
+require git.jaezmien.com/Jaezmien/notitg-party/protocol v0.0.0
+
+replace git.jaezmien.com/Jaezmien/notitg-party/protocol => ../protocol
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
+)
+
+const (
+	BUCKET_MATCHES      = "matches"
+	BUCKET_ROOM_MATCHES = "room_matches"
+)
+
+const (
+	MATCH_PLAYER_PLAYING      = "playing"
+	MATCH_PLAYER_FINISHED     = "finished"
+	MATCH_PLAYER_DISCONNECTED = "disconnected"
+	MATCH_PLAYER_KICKED       = "kicked"
+)
+
+type MatchPlayer struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+
+	Rank   int    `json:"rank"`
+	Status string `json:"status"`
+
+	Finished   bool               `json:"finished"`
+	Score      int32              `json:"score"`
+	Judgments  protocol.Judgments `json:"judgments"`
+	FinishedAt int64              `json:"finished_at"`
+}
+
+type MatchRecord struct {
+	ID        string `json:"id"`
+	RoomID    string `json:"room_id"`
+	RoomTitle string `json:"room_title"`
+
+	SongHash       string `json:"song_hash"`
+	SongDifficulty string `json:"song_difficulty"`
+
+	StartedAt  int64 `json:"started_at"`
+	FinishedAt int64 `json:"finished_at"`
+
+	Players []*MatchPlayer `json:"players"`
+}
+
+func NewMatchRecord(r *Room) *MatchRecord {
+	// XXX: V7 UUIDs are time-ordered, which keeps the matches sorted in the database
+	id, err := uuid.NewV7()
+	if err != nil {
+		panic(fmt.Errorf("uuid: %w", err))
+	}
+
+	return &MatchRecord{
+		ID:             id.String(),
+		RoomID:         r.UUID,
+		RoomTitle:      r.Title,
+		SongHash:       r.SongHash,
+		SongDifficulty: r.SongDifficulty,
+		StartedAt:      time.Now().UnixMilli(),
+		Players:        make([]*MatchPlayer, 0),
+	}
+}
+
+func (m *MatchRecord) AddPlayer(c *Client) {
+	m.Players = append(m.Players, &MatchPlayer{
+		ID:       c.UUID,
+		Username: c.Username,
+		Status:   MATCH_PLAYER_PLAYING,
+	})
+}
+
+func (m *MatchRecord) GetPlayer(id string) *MatchPlayer {
+	for _, p := range m.Players {
+		if p.ID == id {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Keeps track of the player's latest score, in case they never finish
+func (m *MatchRecord) UpdateScore(id string, score int32) {
+	p := m.GetPlayer(id)
+	if p == nil || p.Finished {
+		return
+	}
+
+	p.Score = score
+}
+
+// Marks a player that has left before finishing the match.
+// Only the first reason sticks, so a kick isn't overwritten by the disconnect that follows it.
+func (m *MatchRecord) SetLeft(id string, status string) {
+	p := m.GetPlayer(id)
+	if p == nil || p.Status != MATCH_PLAYER_PLAYING {
+		return
+	}
+
+	p.Status = status
+}
+
+func (m *MatchRecord) SetResult(id string, data protocol.GameplayFinish) {
+	p := m.GetPlayer(id)
+	if p == nil {
+		return
+	}
+
+	p.Finished = true
+	p.Status = MATCH_PLAYER_FINISHED
+	p.Score = data.Score
+	p.Judgments = data.Judgments
+	p.FinishedAt = time.Now().UnixMilli()
+}
+
+// Checks if player a should be ranked above player b
+func ranksAbove(a *MatchPlayer, b *MatchPlayer) bool {
+	// Players who have finished the song always rank above those who didn't
+	if a.Finished != b.Finished {
+		return a.Finished
+	}
+
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+
+	if a.Finished {
+		// Same score, so compare from the best judgment down
+		ja, jb := a.Judgments, b.Judgments
+		if ja.Marvelous != jb.Marvelous {
+			return ja.Marvelous > jb.Marvelous
+		}
+		if ja.Perfect != jb.Perfect {
+			return ja.Perfect > jb.Perfect
+		}
+		if ja.Great != jb.Great {
+			return ja.Great > jb.Great
+		}
+		if ja.Good != jb.Good {
+			return ja.Good > jb.Good
+		}
+		if ja.Miss != jb.Miss {
+			return ja.Miss < jb.Miss
+		}
+		if ja.Boo != jb.Boo {
+			return ja.Boo < jb.Boo
+		}
+
+		// Then whoever got there first
+		if a.FinishedAt != b.FinishedAt {
+			return a.FinishedAt < b.FinishedAt
+		}
+	}
+
+	return a.Username < b.Username
+}
+
+// Sorts the players by their final standing, and assigns their ranks
+func (m *MatchRecord) Rank() {
+	sort.SliceStable(m.Players, func(i, j int) bool {
+		return ranksAbove(m.Players[i], m.Players[j])
+	})
+
+	for i, p := range m.Players {
+		p.Rank = i + 1
+	}
+}
+
+func (m *MatchRecord) Standings() []protocol.Standing {
+	standings := make([]protocol.Standing, 0, len(m.Players))
+	for _, p := range m.Players {
+		standings = append(standings, protocol.Standing{
+			Rank: p.Rank,
+			User: protocol.User{
+				BaseID:   protocol.BaseID{ID: p.ID},
+				Username: p.Username,
+			},
+			Status: p.Status,
+			GameplayFinish: protocol.GameplayFinish{
+				GameplayScore: protocol.GameplayScore{Score: p.Score},
+				Judgments:     p.Judgments,
+			},
+		})
+	}
+
+	return standings
+}
+
+type History struct {
+	DB *bolt.DB
+}
+
+func OpenHistory(path string) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(BUCKET_MATCHES)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(BUCKET_ROOM_MATCHES)); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &History{DB: db}, nil
+}
+
+func (h *History) Close() error {
+	return h.DB.Close()
+}
+
+func (h *History) Save(match *MatchRecord) error {
+	data, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+
+	return h.DB.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(BUCKET_MATCHES)).Put([]byte(match.ID), data); err != nil {
+			return err
+		}
+
+		rB, err := tx.Bucket([]byte(BUCKET_ROOM_MATCHES)).CreateBucketIfNotExists([]byte(match.RoomID))
+		if err != nil {
+			return err
+		}
+		return rB.Put([]byte(match.ID), []byte{})
+	})
+}
+
+// Returns the match with the given id, or nil if it doesn't exist
+func (h *History) Get(id string) (*MatchRecord, error) {
+	var match *MatchRecord
+
+	err := h.DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(BUCKET_MATCHES)).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+
+		match = &MatchRecord{}
+		return json.Unmarshal(data, match)
+	})
+
+	return match, err
+}
+
+// Returns up to limit matches, starting from the most recent one
+func (h *History) List(limit int) ([]*MatchRecord, error) {
+	matches := make([]*MatchRecord, 0)
+
+	err := h.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(BUCKET_MATCHES)).Cursor()
+
+		for k, v := c.Last(); k != nil && len(matches) < limit; k, v = c.Prev() {
+			match := &MatchRecord{}
+			if err := json.Unmarshal(v, match); err != nil {
+				return err
+			}
+			matches = append(matches, match)
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+// Returns up to limit matches played in a room, starting from the most recent one
+func (h *History) ListRoom(roomID string, limit int) ([]*MatchRecord, error) {
+	matches := make([]*MatchRecord, 0)
+
+	err := h.DB.View(func(tx *bolt.Tx) error {
+		rB := tx.Bucket([]byte(BUCKET_ROOM_MATCHES)).Bucket([]byte(roomID))
+		if rB == nil {
+			return nil
+		}
+		mB := tx.Bucket([]byte(BUCKET_MATCHES))
+
+		c := rB.Cursor()
+		for k, _ := c.Last(); k != nil && len(matches) < limit; k, _ = c.Prev() {
+			data := mB.Get(k)
+			if data == nil {
+				continue
+			}
+
+			match := &MatchRecord{}
+			if err := json.Unmarshal(data, match); err != nil {
+				return err
+			}
+			matches = append(matches, match)
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
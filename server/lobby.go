@@ -2,15 +2,28 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sio/coolname"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
 )
 
 type Lobby struct {
 	RoomMutex sync.Mutex
 	Rooms     map[*Room]bool
+
+	// Where finished matches are stored, nil if disabled
+	History *History
+
+	// Registered players, nil if disabled
+	Accounts *Accounts
+
+	shuttingDown atomic.Bool
 }
 
 func NewLobby() *Lobby {
@@ -19,18 +32,50 @@ func NewLobby() *Lobby {
 	}
 }
 
+// Generates a title that fits within the title length limit, using fewer words if it has to
 func CreateLobbyName() string {
-	n, err := coolname.SlugN(3)
-	if err != nil {
-		panic(fmt.Errorf("slug: %w", err))
+	slug := func(words int) string {
+		n, err := coolname.SlugN(words)
+		if err != nil {
+			panic(fmt.Errorf("slug: %w", err))
+		}
+		return n
+	}
+
+	maxLength := CurrentConfig().Moderation.TitleMaxLength
+	for words := 3; words >= 2; words-- {
+		for range 10 {
+			if n := slug(words); len(n) <= maxLength {
+				return n
+			}
+		}
 	}
-	return n
+
+	// The limit is too short for whole names
+	n := slug(2)
+	return n[:min(len(n), maxLength)]
 }
 
-func (l *Lobby) NewRoom() *Room {
+// Creates a room, with a generated title if none is given
+func (l *Lobby) NewRoom(title string, password string, private bool, maxPlayers int) *Room {
+	if title == "" {
+		title = CreateLobbyName()
+	}
+
 	m := &Room{
 		UUID:  uuid.NewString(),
-		Title: CreateLobbyName(),
+		Title: title,
+
+		Private:  private,
+		Password: password,
+
+		MaxPlayers: maxPlayers,
+		Banned:     make(map[string]string),
+		Queue:      make([]protocol.QueueEntry, 0),
+		Vote: SongVote{
+			Nominations: make([]protocol.Nomination, 0),
+			Votes:       make(map[string]string),
+		},
 
 		Lobby:    l,
 		State:    ROOM_IDLE,
@@ -41,8 +86,15 @@ func (l *Lobby) NewRoom() *Room {
 		Join:      make(chan *Client),
 		Leave:     make(chan *Client),
 
+		Disconnect: make(chan *Client),
+		Resume:     make(chan *ClientResume),
+		Do:         make(chan func()),
+
 		Quit: make(chan struct{}),
+
+		CreatedAt: time.Now().UnixMilli(),
 	}
+	m.Logger = logger.With(slog.String("room_id", m.UUID), slog.String("room_title", m.Title))
 
 	l.RoomMutex.Lock()
 	l.Rooms[m] = true
@@ -66,6 +118,8 @@ func (l *Lobby) GetRoom(id string) *Room {
 	return nil
 }
 
+// Closes the room and removes it from the lobby, if it hasn't closed already.
+// Should only be called from inside the room's goroutine.
 func (l *Lobby) CloseRoom(id string) {
 	l.RoomMutex.Lock()
 	defer l.RoomMutex.Unlock()
@@ -77,8 +131,6 @@ func (l *Lobby) CloseRoom(id string) {
 			return
 		}
 	}
-
-	panic("attempted to close a room that doesn't exist")
 }
 
 func (l *Lobby) UsernameExists(username string) bool {
@@ -94,6 +146,58 @@ func (l *Lobby) UsernameExists(username string) bool {
 	return false
 }
 
+func (l *Lobby) IsShuttingDown() bool {
+	return l.shuttingDown.Load()
+}
+
+// Checks if any room is in the middle of a match
+func (l *Lobby) HasActiveMatch() bool {
+	l.RoomMutex.Lock()
+	defer l.RoomMutex.Unlock()
+
+	for m := range l.Rooms {
+		if !m.IsIdle() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stops the lobby from accepting new rooms and players, notifies every room,
+// and waits for any ongoing match to finish (up to the deadline) before closing every room.
+func (l *Lobby) Shutdown(reason string, deadline time.Time) {
+	l.shuttingDown.Store(true)
+
+	l.RoomMutex.Lock()
+	rooms := make([]*Room, 0, len(l.Rooms))
+	for m := range l.Rooms {
+		rooms = append(rooms, m)
+	}
+	l.RoomMutex.Unlock()
+
+	logger.Info("notifying rooms of shutdown", slog.Int("rooms", len(rooms)))
+	data := protocol.NewServerShutdownEvent(reason, deadline.UnixMilli())
+	for _, m := range rooms {
+		select {
+		case m.Broadcast <- data:
+		case <-m.Quit:
+		}
+	}
+
+	for l.HasActiveMatch() && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+	}
+	if l.HasActiveMatch() {
+		logger.Warn("shutdown deadline reached with matches still ongoing")
+	}
+
+	// Rooms that have closed by themselves in the meantime are skipped by Exec
+	for _, m := range rooms {
+		m.Exec(func() { l.CloseRoom(m.UUID) })
+	}
+}
+
 func (l *Lobby) GetRoomCount() int {
 	l.RoomMutex.Lock()
 	defer l.RoomMutex.Unlock()
@@ -102,30 +206,63 @@ func (l *Lobby) GetRoomCount() int {
 }
 
 type RoomSummary struct {
-	ID      string    `json:"id"`
-	Title   string    `json:"title"`
-	Players []string  `json:"players"`
-	State   RoomState `json:"state"`
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Players     []string  `json:"players"`
+	PlayerCount int       `json:"player_count"`
+	Spectators  []string  `json:"spectators"`
+	MaxPlayers  int       `json:"max_players"`
+	State       RoomState `json:"state"`
+	Locked      bool      `json:"locked"`
+
+	// Average round trip time of each user in milliseconds, keyed by their username
+	Latency map[string]int64 `json:"latency"`
+
+	Settings protocol.RoomSettings `json:"settings"`
 }
 
 func (l *Lobby) GetRoomSummary() []RoomSummary {
 	l.RoomMutex.Lock()
-	defer l.RoomMutex.Unlock()
+	rooms := make([]*Room, 0, len(l.Rooms))
+	for m := range l.Rooms {
+		rooms = append(rooms, m)
+	}
+	l.RoomMutex.Unlock()
 
+	// Each room fills in its own summary, as its settings and clients can change at any time.
+	// Rooms that have closed since are skipped by Exec.
 	s := make([]RoomSummary, 0)
-	for m := range l.Rooms {
-		summary := RoomSummary{
-			ID:      m.UUID,
-			Title:   m.Title,
-			State:   m.State,
-			Players: make([]string, 0),
-		}
+	for _, m := range rooms {
+		m.Exec(func() {
+			// Private rooms can only be joined by those who already know the room's id
+			if m.Private {
+				return
+			}
 
-		for p := range m.Clients {
-			summary.Players = append(summary.Players, p.Username)
-		}
+			summary := RoomSummary{
+				ID:         m.UUID,
+				Title:      m.Title,
+				State:      m.State,
+				Locked:     m.IsLocked(),
+				MaxPlayers: m.MaxPlayers,
+				Players:    make([]string, 0),
+				Spectators: make([]string, 0),
+				Latency:    make(map[string]int64),
+				Settings:   m.Settings(),
+			}
+
+			for p := range m.Clients {
+				if p.Spectator {
+					summary.Spectators = append(summary.Spectators, p.Username)
+				} else {
+					summary.Players = append(summary.Players, p.Username)
+				}
+				summary.Latency[p.Username] = p.Latency()
+			}
+			summary.PlayerCount = len(summary.Players)
 
-		s = append(s, summary)
+			s = append(s, summary)
+		})
 	}
 
 	return s
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestCreateLobbyName(t *testing.T) {
+	for _, limit := range []int{32, 12, 4} {
+		useConfig(t, func(c *Config) { c.Moderation.TitleMaxLength = limit })
+
+		for range 1000 {
+			title := CreateLobbyName()
+			if err := ValidateRoomTitle(title); err != nil {
+				t.Fatalf("limit %d: CreateLobbyName() = %q: %v", limit, title, err)
+			}
+		}
+	}
+}
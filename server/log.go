@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+var LogFormat = "text"
+var LogFile = ""
+var LogMaxSizeMB = 10
+var LogMaxBackups = 5
+
+// Builds the logger from the log settings. The returned closer is nil when logging to stdout.
+func NewLogger() (*slog.Logger, io.Closer, error) {
+	var out io.Writer = os.Stdout
+	var closer io.Closer
+
+	if LogFile != "" {
+		f, err := OpenRotatingFile(LogFile, int64(LogMaxSizeMB)*1024*1024, LogMaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = f
+		closer = f
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+
+	switch LogFormat {
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, opts)), closer, nil
+	case "text":
+		return slog.New(slog.NewTextHandler(out, opts)), closer, nil
+	}
+
+	if closer != nil {
+		closer.Close()
+	}
+	return nil, nil, fmt.Errorf("unknown log format %q", LogFormat)
+}
+
+// A log file that gets moved to <path>.1 once it grows past its max size,
+// shifting older files up to <path>.<maxBackups>
+type RotatingFile struct {
+	mu sync.Mutex
+
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// Opens the log file for appending. A max size of 0 disables rotation.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+
+	var err error
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		err = os.Rename(f.path, f.path+".1")
+	} else {
+		err = os.Remove(f.path)
+	}
+
+	// Even if the file couldn't be moved, we still need something to write to
+	return errors.Join(err, f.open())
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "could not rotate log file: %s\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Counters exposed through /metrics, in the Prometheus text format
+type Metrics struct {
+	MatchesStarted  atomic.Uint64
+	MatchesFinished atomic.Uint64
+
+	// Clients removed by Room.Run for not keeping up within a grace period
+	StartGraceKicks  atomic.Uint64
+	EndGraceKicks    atomic.Uint64
+	ResumeGraceKicks atomic.Uint64
+
+	// Clients closed because their send buffer was full
+	BroadcastDrops atomic.Uint64
+
+	ScoresReceived  atomic.Uint64
+	ScoresThrottled atomic.Uint64
+
+	UpgradeFailures atomic.Uint64
+}
+
+var metrics = &Metrics{}
+
+var clientStateNames = map[ClientState]string{
+	CLIENT_IDLE:         "idle",
+	CLIENT_MISSING_SONG: "missing_song",
+	CLIENT_LOBBY_READY:  "lobby_ready",
+	CLIENT_GAME_LOADING: "game_loading",
+	CLIENT_GAME_READY:   "game_ready",
+	CLIENT_PLAYING:      "playing",
+	CLIENT_RESULTS:      "results",
+}
+
+// Counts the clients of every room by their state
+func (l *Lobby) ClientStateCounts() map[ClientState]int {
+	l.RoomMutex.Lock()
+	rooms := make([]*Room, 0, len(l.Rooms))
+	for m := range l.Rooms {
+		rooms = append(rooms, m)
+	}
+	l.RoomMutex.Unlock()
+
+	// Each room counts its own clients, as they can join and leave at any time.
+	// Rooms that have closed since are skipped by Exec.
+	counts := make(map[ClientState]int)
+	for _, m := range rooms {
+		m.Exec(func() {
+			for c := range m.Clients {
+				counts[c.State]++
+			}
+		})
+	}
+
+	return counts
+}
+
+func writeMetric(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func (m *Metrics) Write(w io.Writer, l *Lobby) {
+	writeMetric(w, "party_rooms", "gauge", "Number of open rooms.")
+	fmt.Fprintf(w, "party_rooms %d\n", l.GetRoomCount())
+
+	counts := l.ClientStateCounts()
+	writeMetric(w, "party_clients", "gauge", "Number of clients in a room, by state.")
+	for state := CLIENT_IDLE; state <= CLIENT_RESULTS; state++ {
+		fmt.Fprintf(w, "party_clients{state=%q} %d\n", clientStateNames[state], counts[state])
+	}
+
+	writeMetric(w, "party_matches_started_total", "counter", "Number of matches that have started playing.")
+	fmt.Fprintf(w, "party_matches_started_total %d\n", m.MatchesStarted.Load())
+
+	writeMetric(w, "party_matches_finished_total", "counter", "Number of matches that have finished.")
+	fmt.Fprintf(w, "party_matches_finished_total %d\n", m.MatchesFinished.Load())
+
+	writeMetric(w, "party_grace_kicks_total", "counter", "Number of clients removed after a grace period ran out.")
+	fmt.Fprintf(w, "party_grace_kicks_total{grace=\"start\"} %d\n", m.StartGraceKicks.Load())
+	fmt.Fprintf(w, "party_grace_kicks_total{grace=\"end\"} %d\n", m.EndGraceKicks.Load())
+	fmt.Fprintf(w, "party_grace_kicks_total{grace=\"resume\"} %d\n", m.ResumeGraceKicks.Load())
+
+	writeMetric(w, "party_broadcast_drops_total", "counter", "Number of clients closed because their send buffer was full.")
+	fmt.Fprintf(w, "party_broadcast_drops_total %d\n", m.BroadcastDrops.Load())
+
+	writeMetric(w, "party_score_events_total", "counter", "Number of score events received from players.")
+	fmt.Fprintf(w, "party_score_events_total %d\n", m.ScoresReceived.Load())
+
+	writeMetric(w, "party_score_events_throttled_total", "counter", "Number of score events dropped by the throttle.")
+	fmt.Fprintf(w, "party_score_events_throttled_total %d\n", m.ScoresThrottled.Load())
+
+	writeMetric(w, "party_upgrade_failures_total", "counter", "Number of websocket upgrades that have failed.")
+	fmt.Fprintf(w, "party_upgrade_failures_total %d\n", m.UpgradeFailures.Load())
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Same as the client's username input
+var UsernameMinLength = 1
+var UsernameMaxLength = 16
+
+var RoomTitleMaxLength = 32
+
+// Comma separated words that can't be used in usernames, room titles or chat
+var FilterWords = ""
+
+// File with more filtered words, one per line
+var FilterWordsFile = ""
+
+// The filtered words, normalized
+var filteredWords atomic.Pointer[[]string]
+
+// Letters from other scripts that look just like a latin letter
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'с': 'c', 'ѕ': 's', 'т': 't', 'у': 'y', 'ԝ': 'w', 'х': 'x', 'ԁ': 'd',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'ω': 'w', 'ϲ': 'c',
+	// Others
+	'ı': 'i', 'ȷ': 'j',
+}
+
+var caseFolder = cases.Fold()
+
+// Reduces the text down to what it looks like, so that names such as "Foo", "foo " and "fοο"
+// (with greek omicrons) are treated as the same name.
+func NormalizeName(s string) string {
+	s = caseFolder.String(norm.NFKC.String(s))
+
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		// Accents, invisible characters and spaces
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) || unicode.IsSpace(r) {
+			continue
+		}
+
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Reads the filtered words from the list and the file
+func LoadFilterWords(list string, path string) ([]string, error) {
+	words := strings.Split(list, ",")
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("words file: %w", err)
+		}
+		words = append(words, strings.Split(string(data), "\n")...)
+	}
+
+	normalized := make([]string, 0, len(words))
+	for _, w := range words {
+		if n := NormalizeName(w); n != "" {
+			normalized = append(normalized, n)
+		}
+	}
+
+	return normalized, nil
+}
+
+func SetFilterWords(words []string) {
+	filteredWords.Store(&words)
+}
+
+// Checks if the text contains a filtered word anywhere, even when hidden inside another word
+func ContainsFilteredWord(text string) bool {
+	words := filteredWords.Load()
+	if words == nil {
+		return false
+	}
+
+	normalized := NormalizeName(text)
+	for _, w := range *words {
+		if strings.Contains(normalized, w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Replaces every filtered word in the message with asterisks. Only whole words are replaced,
+// so that innocent words that happen to contain a filtered one are left alone.
+func CensorText(text string) string {
+	words := filteredWords.Load()
+	if words == nil || len(*words) == 0 {
+		return text
+	}
+
+	censor := func(field string) string {
+		word := strings.TrimFunc(field, unicode.IsPunct)
+		normalized := NormalizeName(word)
+
+		for _, w := range *words {
+			if normalized == w {
+				return strings.Replace(field, word, strings.Repeat("*", utf8.RuneCountInString(word)), 1)
+			}
+		}
+		return field
+	}
+
+	var b strings.Builder
+	start := -1
+	for i, r := range text {
+		if !unicode.IsSpace(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			b.WriteString(censor(text[start:i]))
+			start = -1
+		}
+		b.WriteRune(r)
+	}
+	if start >= 0 {
+		b.WriteString(censor(text[start:]))
+	}
+
+	return b.String()
+}
+
+// Checks that the text only uses letters, numbers, single spaces and a few symbols
+func validateName(kind string, text string, minLength int, maxLength int) error {
+	if text == "" {
+		return fmt.Errorf("missing %s", kind)
+	}
+	if text != strings.TrimSpace(text) {
+		return fmt.Errorf("%s can't start or end with a space", kind)
+	}
+
+	length := utf8.RuneCountInString(text)
+	if length < minLength {
+		return fmt.Errorf("%s must be at least %d characters", kind, minLength)
+	}
+	if length > maxLength {
+		return fmt.Errorf("%s can't be longer than %d characters", kind, maxLength)
+	}
+
+	previous := rune(0)
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r), unicode.IsNumber(r), unicode.IsMark(r):
+		case r == ' ':
+			if previous == ' ' {
+				return fmt.Errorf("%s can't have more than one space in a row", kind)
+			}
+		case strings.ContainsRune("_-.'!?", r):
+		default:
+			return fmt.Errorf("%s contains invalid characters", kind)
+		}
+		previous = r
+	}
+
+	if NormalizeName(text) == "" {
+		return fmt.Errorf("%s contains invalid characters", kind)
+	}
+	if ContainsFilteredWord(text) {
+		return errors.New(kind + " contains a filtered word")
+	}
+
+	return nil
+}
+
+func ValidateUsername(username string) error {
+	cfg := CurrentConfig()
+	return validateName("username", username, cfg.Moderation.UsernameMinLength, cfg.Moderation.UsernameMaxLength)
+}
+
+func ValidateRoomTitle(title string) error {
+	return validateName("title", title, 1, CurrentConfig().Moderation.TitleMaxLength)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func useFilterWords(t *testing.T, list string) {
+	t.Helper()
+
+	words, err := LoadFilterWords(list, "")
+	if err != nil {
+		t.Fatalf("LoadFilterWords: %v", err)
+	}
+
+	previous := filteredWords.Load()
+	SetFilterWords(words)
+	t.Cleanup(func() { filteredWords.Store(previous) })
+}
+
+func TestNormalizeName(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"case", "Alice", "alice", true},
+		{"spaces", " ali ce ", "alice", true},
+		{"accents", "Àlíce", "alice", true},
+		{"full width", "ａｌｉｃｅ", "alice", true},
+		{"invisible", "ali\u200bce", "alice", true},
+		{"cyrillic", "\u0430lice", "alice", true},
+		{"greek", "f\u03bf\u03bf", "foo", true},
+		{"different", "alice", "alicia", false},
+		{"digit one", "player1", "playerl", false},
+		{"digit zero", "team10", "teamlo", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, b := NormalizeName(c.a), NormalizeName(c.b)
+			if (a == b) != c.same {
+				t.Fatalf("NormalizeName(%q) = %q, NormalizeName(%q) = %q, same = %v", c.a, a, c.b, b, c.same)
+			}
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	useFilterWords(t, "heck")
+
+	cases := []struct {
+		name     string
+		username string
+		err      string
+	}{
+		{"plain", "alice", ""},
+		{"digits", "bob1", ""},
+		{"symbols", "mr. alice!", ""},
+		{"unicode", "Ünïcødé", ""},
+		{"empty", "", "missing username"},
+		{"leading space", " alice", "can't start or end with a space"},
+		{"trailing space", "alice ", "can't start or end with a space"},
+		{"too long", strings.Repeat("a", CurrentConfig().Moderation.UsernameMaxLength+1), "can't be longer than"},
+		{"double space", "a  b", "more than one space"},
+		{"invalid character", "alice<3", "invalid characters"},
+		{"only invisible", "\u200b", "invalid characters"},
+		{"filtered", "heckler", "filtered word"},
+		{"filtered lookalike", "h\u0435ck", "filtered word"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidateUsername(c.username)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("ValidateUsername(%q) = %v, want nil", c.username, err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("ValidateUsername(%q) = %v, want %q", c.username, err, c.err)
+			}
+		})
+	}
+}
+
+func TestCensorText(t *testing.T) {
+	useFilterWords(t, "heck, darn")
+
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"clean", "hello there", "hello there"},
+		{"word", "what the heck", "what the ****"},
+		{"case", "HECK", "****"},
+		{"punctuation", "heck! darn.", "****! ****."},
+		{"lookalike", "h\u0435ck", "****"},
+		{"inside another word", "check heckler", "check heckler"},
+		{"after a longer word", "check heck", "check ****"},
+		{"whitespace", "a  heck\tb", "a  ****\tb"},
+		{"empty", "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := CensorText(c.text); got != c.want {
+				t.Fatalf("CensorText(%q) = %q, want %q", c.text, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
+)
+
+// The most songs a room can have queued up
+var RoomQueueMaxLength = 50
+
+// Checks if the client is allowed to add, remove and move songs in the queue
+func (r *Room) CanEditQueue(c *Client) bool {
+	return c.Host || (r.OpenQueue && !c.Spectator)
+}
+
+func (r *Room) BroadcastQueue() {
+	r.BroadcastAll(protocol.NewRoomQueueEvent(r.Queue))
+}
+
+func (r *Room) queueIndex(id string) int {
+	return slices.IndexFunc(r.Queue, func(e protocol.QueueEntry) bool {
+		return e.ID == id
+	})
+}
+
+// Adds the song to the end of the queue, or loads it right away if the room has nothing to play
+func (r *Room) AddToQueue(c *Client, hash string, difficulty string) error {
+	if maxLength := CurrentConfig().Room.QueueMaxLength; len(r.Queue) >= maxLength {
+		return fmt.Errorf("queue can't have more than %d songs", maxLength)
+	}
+	if !r.AllowsDifficulty(difficulty) {
+		return errors.New("difficulty is outside of the room's range")
+	}
+
+	r.Queue = append(r.Queue, protocol.QueueEntry{
+		BaseID:  protocol.BaseID{ID: uuid.NewString()},
+		SetSong: protocol.SetSong{Hash: hash, Difficulty: difficulty},
+		AddedBy: protocol.User{BaseID: protocol.BaseID{ID: c.UUID}, Username: c.Username},
+	})
+	c.Logger.Info("song has been added to the queue")
+
+	if r.IsIdle() && r.SongHash == "" {
+		r.NextSong()
+		return nil
+	}
+
+	r.BroadcastQueue()
+	return nil
+}
+
+// Returns false if there's no such entry in the queue
+func (r *Room) RemoveFromQueue(id string) bool {
+	i := r.queueIndex(id)
+	if i < 0 {
+		return false
+	}
+
+	r.Queue = slices.Delete(r.Queue, i, i+1)
+	r.BroadcastQueue()
+	return true
+}
+
+// Moves the entry to the given position, or to the end of the queue if it's past it.
+// Returns false if there's no such entry in the queue.
+func (r *Room) MoveInQueue(id string, index int) bool {
+	i := r.queueIndex(id)
+	if i < 0 {
+		return false
+	}
+
+	entry := r.Queue[i]
+	r.Queue = slices.Delete(r.Queue, i, i+1)
+	r.Queue = slices.Insert(r.Queue, min(index, len(r.Queue)), entry)
+
+	r.BroadcastQueue()
+	return true
+}
+
+// Loads the next song in the queue, skipping any that the room's settings no longer allow
+func (r *Room) NextSong() {
+	if len(r.Queue) == 0 {
+		return
+	}
+
+	for len(r.Queue) > 0 {
+		entry := r.Queue[0]
+		r.Queue = r.Queue[1:]
+
+		if !r.AllowsDifficulty(entry.Difficulty) {
+			r.Logger.Info("skipping queued song outside of the room's difficulty range")
+			continue
+		}
+
+		r.Logger.Info("loading the next song in the queue")
+		r.SetSong(entry.Hash, entry.Difficulty)
+		break
+	}
+
+	r.BroadcastQueue()
+}
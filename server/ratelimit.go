@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Proxies whose X-Forwarded-For header we believe
+var TrustedProxiesValue = ""
+
+// The parsed trusted proxies
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// The most rooms that can be open at once, 0 if unlimited
+var LobbyMaxRooms = 0
+
+var CreatePerMinute = 10
+var CreateBurst = 3
+var JoinPerMinute = 30
+var JoinBurst = 10
+var AuthPerMinute = 10
+var AuthBurst = 5
+
+var createLimiter = NewRateLimiter()
+var joinLimiter = NewRateLimiter()
+var authLimiter = NewRateLimiter()
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Token bucket rate limiter, keyed by the client's IP
+type RateLimiter struct {
+	mu sync.Mutex
+
+	// Tokens refilled per second, 0 if disabled
+	rate  float64
+	burst float64
+
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Sets how many requests are allowed per minute, and how many can be made at once. 0 per minute disables the limit.
+func (l *RateLimiter) SetLimit(perMinute int, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = float64(perMinute) / 60
+	l.burst = float64(burst)
+}
+
+// Takes a token from the key's bucket. If there's none left, returns how long until the next one.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// Forgets buckets that have refilled, so that the map doesn't grow forever
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Parses a comma separated list of IPs and CIDR ranges
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	proxies := make([]netip.Prefix, 0)
+
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if strings.Contains(v, "/") {
+			p, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy range %q", v)
+			}
+			proxies = append(proxies, p.Masked())
+			continue
+		}
+
+		ip, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q", v)
+		}
+		ip = ip.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+
+	return proxies, nil
+}
+
+func isTrustedProxy(ip netip.Addr) bool {
+	proxies := trustedProxies.Load()
+	if proxies == nil {
+		return false
+	}
+
+	for _, p := range *proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the IP of whoever made the request. Requests coming from a trusted proxy are
+// traced back through X-Forwarded-For, up to the first address that isn't a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	ip = ip.Unmap()
+
+	if !isTrustedProxy(ip) {
+		return ip.String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip.String()
+}
+
+// Checks the request against the limiter, writing the 429 response if it's over the limit
+func allowRequest(l *RateLimiter, w http.ResponseWriter, r *http.Request) bool {
+	ip := clientIP(r)
+
+	ok, wait := l.Allow(ip)
+	if !ok {
+		logger.Debug("rate limited request", slog.String("ip", ip), slog.String("path", r.URL.Path))
+
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+		writeJSONStatus(w, http.StatusTooManyRequests, APIError{Code: "rate_limited", Message: "too many requests, try again later"})
+		return false
+	}
+
+	return true
+}
@@ -1,19 +1,37 @@
 package main
 
 import (
+	"crypto/subtle"
+	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
-	"git.jaezmien.com/Jaezmien/notitg-party/server/events"
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
 )
 
 type RoomState int
 
 var RoomStartGracePeriod = time.Duration(time.Second * 15).Milliseconds()
 var RoomEndGracePeriod = time.Duration(time.Second * 15).Milliseconds()
+var RoomResumeGracePeriod = time.Duration(time.Second * 30).Milliseconds()
+
+// How far ahead the match is scheduled to start, giving every client time to receive the start event
+var RoomStartCountdown = time.Duration(time.Second * 3).Milliseconds()
+var RoomChatMaxLength = 200
+var RoomChatBacklogSize = 20
+
+// How often the room checks on its grace periods and reconnecting clients
+var RoomTickInterval = time.Second * 5
+
+// The max_players value of rooms created without one, and the highest value allowed (0 if unlimited)
+var RoomDefaultMaxPlayers = 0
+var RoomMaxPlayersLimit = 0
+
+// How long a room can stay open without anyone in it, 0 to keep it open
+var RoomEmptyTimeout = time.Duration(time.Second * 60).Milliseconds()
 
 const (
 	ROOM_IDLE RoomState = iota
@@ -25,8 +43,39 @@ type Room struct {
 	UUID  string
 	Title string
 
+	Private  bool
+	Password string
+
+	// The maximum amount of players allowed in the room, 0 if unlimited
+	MaxPlayers int
+
+	// Starts the match once every player is ready, without waiting for the host
+	AutoStart bool
+
+	// The range of difficulties that the host can pick from, empty if unbounded
+	MinDifficulty string
+	MaxDifficulty string
+
+	// Songs to load after the current one, in order
+	Queue []protocol.QueueEntry
+
+	// Lets everyone edit the queue, not just the host
+	OpenQueue bool
+
+	// Has the players pick the next song by vote
+	Voting bool
+	Vote   SongVote
+
+	// Usernames that the host has banned from the room, keyed by their normalized name
+	Banned map[string]string
+
+	joinCounter int
+
 	Lobby *Lobby
 
+	// Tags every line with the room's id and title
+	Logger *slog.Logger
+
 	State RoomState
 
 	SongHash       string
@@ -37,10 +86,30 @@ type Room struct {
 	Join      chan *Client
 	Leave     chan *Client
 
+	Disconnect chan *Client
+	Resume     chan *ClientResume
+
+	// Runs the function inside the room's goroutine
+	Do chan func()
+
 	Quit chan struct{}
 
+	CreatedAt int64
+
 	MatchStart int64
 	MatchEnd   int64
+
+	// The results of the ongoing match
+	Match *MatchRecord
+
+	// The most recent chat messages, replayed to clients when they join
+	ChatBacklog [][]byte
+}
+
+type ClientResume struct {
+	// The resume token handed out in self.user
+	Token      string
+	Connection *websocket.Conn
 }
 
 func (r *Room) IsIdle() bool {
@@ -53,13 +122,129 @@ func (r *Room) IsPlaying() bool {
 	return r.State == ROOM_PLAYING
 }
 
+// Checks if the room requires a password to join
+func (r *Room) IsLocked() bool {
+	return r.Password != ""
+}
+
+// Checks if the password matches the room's password, if it has one
+func (r *Room) CheckPassword(password string) bool {
+	if !r.IsLocked() {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Password), []byte(password)) == 1
+}
+
 func (r *Room) ClientCount() int {
 	return len(r.Clients)
 }
 
+// Returns the amount of clients in the room that aren't spectating
+func (r *Room) PlayerCount() int {
+	count := 0
+	for client := range r.Clients {
+		if !client.Spectator {
+			count++
+		}
+	}
+	return count
+}
+
+// Checks if the room can no longer accept any more players
+func (r *Room) IsFull() bool {
+	if r.MaxPlayers <= 0 {
+		return false
+	}
+
+	return r.PlayerCount() >= r.MaxPlayers
+}
+
+func (r *Room) Settings() protocol.RoomSettings {
+	return protocol.RoomSettings{
+		Title:         r.Title,
+		MaxPlayers:    r.MaxPlayers,
+		Private:       r.Private,
+		AutoStart:     r.AutoStart,
+		MinDifficulty: r.MinDifficulty,
+		MaxDifficulty: r.MaxDifficulty,
+		OpenQueue:     r.OpenQueue,
+		Voting:        r.Voting,
+	}
+}
+
+// Checks the settings sent by the host against the server's rules.
+// The title is only checked if it has changed, so a room can keep whatever title it was created with.
+func (r *Room) ValidateSettings(settings protocol.RoomSettings) error {
+	if settings.Title != r.Title {
+		if err := ValidateRoomTitle(settings.Title); err != nil {
+			return err
+		}
+	}
+	if limit := CurrentConfig().Limits.MaxPlayers; limit > 0 && (settings.MaxPlayers == 0 || settings.MaxPlayers > limit) {
+		return fmt.Errorf("max players must be between 1 and %d", limit)
+	}
+
+	return nil
+}
+
+// Applies the settings sent by the host, which should already be validated
+func (r *Room) SetSettings(settings protocol.RoomSettings) {
+	if settings.Title != r.Title {
+		// The loggers keep the title the room was created with, as the clients are still using them.
+		// Its id stays the same, so the rename is logged to tie the two titles together.
+		r.Logger.Info("room title has changed", slog.String("title", settings.Title))
+		r.Title = settings.Title
+
+		r.BroadcastAll(protocol.NewRoomTitleEvent(r.Title))
+	}
+	if settings.MaxPlayers != r.MaxPlayers {
+		r.MaxPlayers = settings.MaxPlayers
+		r.BroadcastAll(protocol.NewRoomCapacityEvent(r.MaxPlayers))
+	}
+
+	r.Private = settings.Private
+	r.AutoStart = settings.AutoStart
+	r.MinDifficulty = settings.MinDifficulty
+	r.MaxDifficulty = settings.MaxDifficulty
+	r.OpenQueue = settings.OpenQueue
+
+	if r.Voting && !settings.Voting && r.IsVoting() {
+		r.ResetVote()
+		r.BroadcastVote("")
+	}
+	r.Voting = settings.Voting
+
+	r.Logger.Info("room settings have changed")
+	r.BroadcastAll(protocol.NewRoomSettingsEvent(r.Settings()))
+
+	r.TryAutoStart()
+}
+
+// Checks if the difficulty is within the room's range.
+// Edits can be of any difficulty, so they're only allowed if the range is unbounded.
+func (r *Room) AllowsDifficulty(difficulty string) bool {
+	if r.MinDifficulty == "" && r.MaxDifficulty == "" {
+		return true
+	}
+
+	i := protocol.DifficultyIndex(difficulty)
+	if i < 0 {
+		return false
+	}
+	if r.MinDifficulty != "" && i < protocol.DifficultyIndex(r.MinDifficulty) {
+		return false
+	}
+	if r.MaxDifficulty != "" && i > protocol.DifficultyIndex(r.MaxDifficulty) {
+		return false
+	}
+
+	return true
+}
+
 func (r *Room) SetNewState(state RoomState) {
 	r.State = state
-	r.BroadcastAll(events.NewRoomStateEvent(int(state)))
+	r.BroadcastAll(protocol.NewRoomStateEvent(int(state)))
 }
 
 func (r *Room) UpdateExpectedMatchEnd() {
@@ -79,14 +264,66 @@ func (r *Room) GetClientFromUsername(username string) *Client {
 
 	return nil
 }
+func (r *Room) GetClientFromID(id string) *Client {
+	for client := range r.Clients {
+		if client.UUID == id {
+			return client
+		}
+	}
+
+	return nil
+}
+func (r *Room) GetClientFromResumeToken(token string) *Client {
+	for client := range r.Clients {
+		if subtle.ConstantTimeCompare([]byte(client.ResumeToken), []byte(token)) == 1 {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// Checks if someone in the room has the same, or a lookalike, username
 func (r *Room) UsernameExists(username string) bool {
-	client := r.GetClientFromUsername(username)
-	return client != nil
+	normalized := NormalizeName(username)
+	for client := range r.Clients {
+		if NormalizeName(client.Username) == normalized {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Room) IsBanned(username string) bool {
+	_, banned := r.Banned[NormalizeName(username)]
+	return banned
+}
+
+// Removes the client from the room, letting them know why.
+// If banned, the client will no longer be able to join the room.
+func (r *Room) KickClient(c *Client, reason string, ban bool) {
+	if ban {
+		r.Banned[NormalizeName(c.Username)] = c.Username
+	}
+
+	c.Logger.Info("user has been kicked from a room", slog.Bool("banned", ban))
+
+	select {
+	case c.Send <- protocol.NewKickedEvent(reason, ban):
+	default:
+	}
+
+	r.RemoveClient(c)
 }
 
 // Checks if all players in the room doesn't have the song
 func (r *Room) AllPlayersMissingSong() bool {
 	for client := range r.Clients {
+		if client.Spectator {
+			continue
+		}
+
 		if client.State != CLIENT_MISSING_SONG {
 			return false
 		}
@@ -101,11 +338,20 @@ func (r *Room) IsReadyToStart() bool {
 		return false
 	}
 
+	// The song is about to change
+	if r.IsVoting() {
+		return false
+	}
+
 	if r.AllPlayersMissingSong() {
 		return false
 	}
 
 	for client := range r.Clients {
+		if client.Spectator {
+			continue
+		}
+
 		if client.State != CLIENT_LOBBY_READY && client.State != CLIENT_MISSING_SONG {
 			return false
 		}
@@ -161,26 +407,55 @@ func (r *Room) ForClientInMatch(callback func(c *Client)) {
 	}
 }
 
+// Runs the callback for every client that should receive the match's data,
+// which are the players in the match and the spectators.
+func (r *Room) ForClientWatching(callback func(c *Client)) {
+	for cl := range r.Clients {
+		if !cl.InMatch && !cl.Spectator {
+			continue
+		}
+
+		callback(cl)
+	}
+}
+
+// Readies the room for a match once every player is ready, if the room is set to start by itself
+func (r *Room) TryAutoStart() {
+	if !r.AutoStart || r.SongHash == "" {
+		return
+	}
+
+	r.ReadyMatch()
+}
+
 // Attempts to ready the room for a match
 func (r *Room) ReadyMatch() {
 	if !r.IsReadyToStart() {
 		return
 	}
 
+	// Don't start anything new if we're about to close
+	if r.Lobby.IsShuttingDown() {
+		return
+	}
+
+	r.Match = NewMatchRecord(r)
+
 	for c := range r.Clients {
-		if c.State == CLIENT_MISSING_SONG {
+		if c.Spectator || c.State == CLIENT_MISSING_SONG {
 			continue
 		}
 		c.InMatch = true
+		r.Match.AddPlayer(c)
 
 		c.SetNewState(CLIENT_GAME_LOADING)
-		c.Send <- events.NewRoomStartEvent()
+		c.Send <- protocol.NewRoomStartEvent()
 	}
 
 	r.MatchStart = time.Now().UnixMilli()
 	r.MatchEnd = 0
 	r.SetNewState(ROOM_PREPARING)
-	logger.Info("room is setting up for gameplay", slog.String("id", r.UUID))
+	r.Logger.Info("room is setting up for gameplay")
 }
 
 // Attempts to start the match
@@ -191,13 +466,15 @@ func (r *Room) StartMatch(force bool) {
 		}
 	}
 
+	startAt := time.Now().UnixMilli() + CurrentConfig().Room.StartCountdown.Milliseconds()
 	r.ForClientInMatch(func(c *Client) {
 		c.SetNewState(CLIENT_PLAYING)
-		c.Send <- events.NewGameplayStartEvent()
+		c.Send <- protocol.NewGameplayStartEvent(startAt)
 	})
 
 	r.SetNewState(ROOM_PLAYING)
-	logger.Info("room has started playing", slog.String("id", r.UUID))
+	metrics.MatchesStarted.Add(1)
+	r.Logger.Info("room has started playing")
 }
 
 // Attempts to finish the mamtch
@@ -208,25 +485,48 @@ func (r *Room) FinishMatch(force bool) {
 		}
 	}
 
-	logger.Info("room has finished song", slog.String("id", r.UUID))
+	r.Logger.Info("room has finished song")
+	metrics.MatchesFinished.Add(1)
 
-	r.ForClientInMatch(func(c *Client) {
-		c.InMatch = false
-		c.SetNewState(CLIENT_IDLE)
+	standings := make([]protocol.Standing, 0)
+	if r.Match != nil {
+		r.Match.Rank()
+		standings = r.Match.Standings()
+	}
+
+	r.ForClientWatching(func(c *Client) {
+		if c.InMatch {
+			c.InMatch = false
+			c.SetNewState(CLIENT_IDLE)
+		}
 
-		c.Send <- events.NewEvaluationRevealEvent()
+		c.Send <- protocol.NewEvaluationRevealEvent(standings)
 	})
 
+	if r.Match != nil {
+		r.Match.FinishedAt = time.Now().UnixMilli()
+
+		if r.Lobby.History != nil {
+			if err := r.Lobby.History.Save(r.Match); err != nil {
+				r.Logger.Error("error while saving match history", slog.Any("err", err))
+			}
+		}
+
+		r.Match = nil
+	}
+
 	r.MatchStart = 0
 	r.MatchEnd = 0
 	r.SetNewState(ROOM_IDLE)
+
+	r.NextSong()
 }
 
 func (r *Room) Run() {
-	logger.Info("new room created", slog.String("id", r.UUID))
-	defer logger.Info("room has closed", slog.String("id", r.UUID))
+	r.Logger.Info("new room created")
+	defer r.Logger.Info("room has closed")
 
-	ticker := time.NewTicker(time.Second * time.Duration(5))
+	ticker := time.NewTicker(RoomTickInterval)
 	defer ticker.Stop()
 
 	for {
@@ -235,28 +535,41 @@ func (r *Room) Run() {
 			return
 
 		case <-ticker.C:
-			if r.State == ROOM_PREPARING && r.MatchStart != 0 && time.Now().UnixMilli() >= r.MatchStart+RoomStartGracePeriod {
-				logger.Warn("room is preparing for 30 seconds, but not every client is ready. kicking clients.", slog.String("room id", r.UUID))
+			cfg := CurrentConfig()
+
+			// Nobody has joined the room since it was created
+			if cfg.Limits.EmptyRoomTimeout > 0 && r.ClientCount() == 0 && time.Now().UnixMilli() >= r.CreatedAt+cfg.Limits.EmptyRoomTimeout.Milliseconds() {
+				r.Logger.Info("nobody has joined the room, exiting room")
+				r.Lobby.CloseRoom(r.UUID)
+				continue
+			}
+
+			if r.State == ROOM_PREPARING && r.MatchStart != 0 && time.Now().UnixMilli() >= r.MatchStart+cfg.Room.StartGrace.Milliseconds() {
+				r.Logger.Warn("room is preparing for 30 seconds, but not every client is ready. kicking clients.")
 
 				r.ForClientInMatch(func(c *Client) {
 					if c.State != CLIENT_GAME_READY {
-						r.CloseClient(c)
+						metrics.StartGraceKicks.Add(1)
+						r.Match.SetLeft(c.UUID, MATCH_PLAYER_KICKED)
+						r.RemoveClient(c)
 					}
 				})
 
 				// Safety check
 				if r.ClientCount() > 0 {
-					logger.Warn("forcing match to start", slog.String("room id", r.UUID))
+					r.Logger.Warn("forcing match to start")
 					r.StartMatch(true)
 				}
 			}
 
-			if r.State == ROOM_PLAYING && r.MatchEnd != 0 && time.Now().UnixMilli() >= r.MatchEnd+RoomEndGracePeriod {
-				logger.Warn("match has ended with players still playing for 30 seconds, forcing match end.", slog.String("room id", r.UUID))
+			if r.State == ROOM_PLAYING && r.MatchEnd != 0 && time.Now().UnixMilli() >= r.MatchEnd+cfg.Room.EndGrace.Milliseconds() {
+				r.Logger.Warn("match has ended with players still playing for 30 seconds, forcing match end.")
 
 				r.ForClientInMatch(func(c *Client) {
 					if c.State != CLIENT_RESULTS {
-						r.CloseClient(c)
+						metrics.EndGraceKicks.Add(1)
+						r.Match.SetLeft(c.UUID, MATCH_PLAYER_KICKED)
+						r.RemoveClient(c)
 					}
 				})
 
@@ -264,25 +577,59 @@ func (r *Room) Run() {
 				r.FinishMatch(true)
 			}
 
+			r.BroadcastLatency()
+
+			for client := range r.Clients {
+				if client.Reconnecting && time.Now().UnixMilli() >= client.ReconnectDeadline {
+					client.Logger.Info("user did not reconnect in time, removing from room")
+					metrics.ResumeGraceKicks.Add(1)
+					r.RemoveClient(client)
+				}
+			}
+
 		case client := <-r.Join:
+			// Someone else might have taken the last spot since the client was let in
+			if !client.Spectator && r.IsFull() {
+				client.Logger.Info("room filled up before the user could join")
+				client.Send <- protocol.NewKickedEvent("The room is full", false)
+				client.Close()
+				break
+			}
+
+			client.JoinOrder = r.joinCounter
+			r.joinCounter++
+
 			r.Clients[client] = true
-			logger.Info("user has joined a room", slog.String("username", client.Username), slog.String("room id", r.UUID))
+			client.Logger.Info("user has joined a room", slog.Bool("spectator", client.Spectator))
+
+			// Let the client know who they're talking to
+			client.Send <- protocol.NewHelloEvent(BuildVersion, protocol.ProtocolVersion, ServerFeatures)
 
 			// Send user's own data
-			client.Send <- events.NewUserInfoEvent(client.Username, client.UUID)
+			client.Send <- protocol.NewUserInfoEvent(client.UUID, client.ResumeToken)
 
 			// Send room title
-			client.Send <- events.NewRoomTitleEvent(r.Title)
+			client.Send <- protocol.NewRoomTitleEvent(r.Title)
 
 			// Send room id
-			client.Send <- events.NewRoomIDEvent(r.UUID)
+			client.Send <- protocol.NewRoomIDEvent(r.UUID)
 
 			// Send room state
-			client.Send <- events.NewRoomStateEvent(int(r.State))
+			client.Send <- protocol.NewRoomStateEvent(int(r.State))
+
+			// Send room capacity
+			client.Send <- protocol.NewRoomCapacityEvent(r.MaxPlayers)
+
+			// Send room settings
+			client.Send <- protocol.NewRoomSettingsEvent(r.Settings())
 
 			// Simulate the other players joining the room
 			for cli := range r.Clients {
-				client.Send <- events.NewUserJoinEvent(cli.Username, cli.UUID, int(cli.State))
+				client.Send <- protocol.NewUserJoinEvent(cli.Username, cli.UUID, int(cli.State), cli.Spectator)
+
+				if cli.Reconnecting {
+					client.Send <- protocol.NewUserReconnectingEvent(cli.UUID)
+				}
 			}
 
 			// If there is only one user after joining, "reroll" the host
@@ -290,44 +637,174 @@ func (r *Room) Run() {
 				r.RollNewHost()
 			}
 			if host := r.GetHost(); host != nil {
-				client.Send <- events.NewRoomHostEvent(host.UUID)
+				client.Send <- protocol.NewRoomHostEvent(host.UUID)
 			}
 
 			if r.SongHash != "" {
-				client.Send <- events.NewRoomSongEvent(r.SongHash, r.SongDifficulty)
+				client.Send <- protocol.NewRoomSongEvent(r.SongHash, r.SongDifficulty)
+			}
+
+			if len(r.Queue) > 0 {
+				client.Send <- protocol.NewRoomQueueEvent(r.Queue)
+			}
+
+			if r.IsVoting() {
+				client.Send <- protocol.NewRoomVoteEvent(r.VoteStatus(""))
+			}
+
+			for _, message := range r.ChatBacklog {
+				client.Send <- message
 			}
 
 			// Send join event to the other clients
 			r.BroadcastExcept(
 				client.UUID,
-				events.NewUserJoinEvent(client.Username, client.UUID, int(client.State)),
+				protocol.NewUserJoinEvent(client.Username, client.UUID, int(client.State), client.Spectator),
 			)
 
 		case client := <-r.Leave:
 			if _, ok := r.Clients[client]; ok {
-				r.CloseClient(client)
-				logger.Info("user has left a room", slog.String("user", client.UUID), slog.String("room id", r.UUID))
-
-				if r.ClientCount() <= 0 {
-					logger.Info("all users have left a room, exiting room", slog.String("id", r.UUID))
-					r.Lobby.CloseRoom(r.UUID)
-				} else {
-					r.BroadcastExcept(
-						client.UUID,
-						events.NewUserLeaveEvent(client.UUID),
-					)
-
-					if client.Host {
-						logger.Info("host has left a room, selecting new host", slog.String("room id", r.UUID))
-						r.RollNewHost()
-						r.BroadcastHost()
-					}
-				}
+				r.RemoveClient(client)
+			}
+
+		case client := <-r.Disconnect:
+			if _, ok := r.Clients[client]; ok && !client.Reconnecting {
+				client.Detach()
+				client.Logger.Info("user has lost connection, waiting for them to reconnect")
+
+				r.BroadcastExcept(
+					client.UUID,
+					protocol.NewUserReconnectingEvent(client.UUID),
+				)
+			}
+
+		case resume := <-r.Resume:
+			client := r.GetClientFromResumeToken(resume.Token)
+			if client == nil {
+				// The client has been removed since the token was checked
+				resume.Connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "unknown session"))
+				resume.Connection.Close()
+				break
 			}
 
+			// We might not have noticed that the old connection is gone yet, so let the new one take its place
+			if !client.Reconnecting {
+				client.Logger.Info("user has resumed before their old connection timed out, dropping it")
+				client.Detach()
+			}
+
+			client.Attach(resume.Connection)
+			client.Send <- protocol.NewHelloEvent(BuildVersion, protocol.ProtocolVersion, ServerFeatures)
+			client.Logger.Info("user has reconnected to a room")
+
+			r.BroadcastExcept(
+				client.UUID,
+				protocol.NewUserResumeEvent(client.UUID),
+			)
+
 		case message := <-r.Broadcast:
 			r.BroadcastAll(message)
+
+		case f := <-r.Do:
+			f()
+		}
+	}
+}
+
+// Runs the function inside the room's goroutine and waits for it to finish.
+// Returns false if the room has closed before it could run.
+func (r *Room) Exec(f func()) bool {
+	done := make(chan struct{})
+
+	select {
+	case r.Do <- func() {
+		f()
+		close(done)
+	}:
+		<-done
+		return true
+	case <-r.Quit:
+		return false
+	}
+}
+
+// Sends a chat message from the client to everyone, and keeps it for those who join later
+func (r *Room) SendChat(c *Client, message string) {
+	event := protocol.NewChatEvent(c.Username, c.UUID, message, time.Now().UnixMilli())
+
+	r.ChatBacklog = append(r.ChatBacklog, event)
+	if size := CurrentConfig().Room.ChatBacklog; len(r.ChatBacklog) > size {
+		r.ChatBacklog = r.ChatBacklog[len(r.ChatBacklog)-size:]
+	}
+
+	r.BroadcastAll(event)
+}
+
+// Lets everyone know how good everyone else's connection is
+func (r *Room) BroadcastLatency() {
+	users := make([]protocol.UserLatency, 0, len(r.Clients))
+	for cli := range r.Clients {
+		if cli.Reconnecting {
+			continue
 		}
+
+		users = append(users, protocol.UserLatency{
+			BaseID:  protocol.BaseID{ID: cli.UUID},
+			Latency: cli.Latency(),
+		})
+	}
+
+	r.BroadcastAll(protocol.NewUserLatencyEvent(users))
+}
+
+// Removes the client from the room, and lets everyone else know that they've left
+func (r *Room) RemoveClient(client *Client) {
+	if client.InMatch && r.Match != nil {
+		r.Match.SetLeft(client.UUID, MATCH_PLAYER_DISCONNECTED)
+	}
+
+	r.CloseClient(client)
+	client.Logger.Info("user has left a room")
+
+	if r.ClientCount() <= 0 {
+		r.Logger.Info("all users have left a room, exiting room")
+		r.Lobby.CloseRoom(r.UUID)
+		return
+	}
+
+	r.BroadcastExcept(
+		client.UUID,
+		protocol.NewUserLeaveEvent(client.UUID),
+	)
+
+	if client.Host {
+		r.Logger.Info("host has left a room, selecting new host")
+		r.RollNewHost()
+		r.BroadcastHost()
+	}
+
+	r.RemoveVoter(client)
+
+	// Whoever was holding everyone up might have just left
+	r.TryAutoStart()
+}
+
+// Lets both us and the client know that it has sent something we couldn't make sense of
+func (r *Room) InvalidEvent(c *Client, t protocol.EventType, err error) {
+	c.Logger.Warn("invalid client data", slog.String("event", string(t)), slog.Any("err", err))
+	r.SendTo(c, protocol.NewErrorEvent(t, err.Error()))
+}
+
+// Sends data to a single client, as long as it's still in the room.
+// The data is dropped if the client isn't keeping up, so that it can't hold up the room.
+func (r *Room) SendTo(c *Client, data []byte) {
+	if _, ok := r.Clients[c]; !ok || c.Closed.Load() {
+		return
+	}
+
+	select {
+	case c.Send <- data:
+	default:
 	}
 }
 
@@ -336,6 +813,7 @@ func (r *Room) BroadcastAll(data []byte) {
 		select {
 		case cli.Send <- data:
 		default:
+			metrics.BroadcastDrops.Add(1)
 			r.CloseClient(cli)
 		}
 	}
@@ -346,6 +824,7 @@ func (r *Room) BroadcastExcept(clientID string, data []byte) {
 			select {
 			case cli.Send <- data:
 			default:
+				metrics.BroadcastDrops.Add(1)
 				r.CloseClient(cli)
 			}
 		}
@@ -366,12 +845,37 @@ func (r *Room) RollNewHost() {
 		cli.Host = false
 	}
 
-	// Pick the first client, and allow them to be host
+	// Pick the player who has been in the room the longest, preferring those who are still connected
+	var host *Client
 	for cli := range r.Clients {
-		logger.Info("a new host has been selected for a room", slog.String("user id", cli.UUID), slog.String("room id", r.UUID))
-		cli.Host = true
+		if cli.Spectator {
+			continue
+		}
+
+		if host == nil ||
+			(host.Reconnecting && !cli.Reconnecting) ||
+			(host.Reconnecting == cli.Reconnecting && cli.JoinOrder < host.JoinOrder) {
+			host = cli
+		}
+	}
+
+	if host == nil {
 		return
 	}
+
+	host.Logger.Info("a new host has been selected for a room")
+	host.Host = true
+}
+
+// Hands the host status over to the given client
+func (r *Room) TransferHost(c *Client) {
+	for cli := range r.Clients {
+		cli.Host = false
+	}
+	c.Host = true
+
+	c.Logger.Info("host has been transferred")
+	r.BroadcastHost()
 }
 func (r *Room) GetHost() *Client {
 	for cli := range r.Clients {
@@ -384,7 +888,7 @@ func (r *Room) GetHost() *Client {
 func (r *Room) BroadcastHost() {
 	for cli := range r.Clients {
 		if cli.Host {
-			r.BroadcastAll(events.NewRoomHostEvent(cli.UUID))
+			r.BroadcastAll(protocol.NewRoomHostEvent(cli.UUID))
 			return
 		}
 	}
@@ -396,14 +900,18 @@ func (r *Room) SetSong(hash string, difficulty string) {
 
 	// Reset client states
 	for cli := range r.Clients {
+		if cli.Spectator {
+			continue
+		}
+
 		cli.SetNewState(CLIENT_MISSING_SONG)
 	}
 
-	r.BroadcastAll(events.NewRoomSongEvent(hash, difficulty))
+	r.BroadcastAll(protocol.NewRoomSongEvent(hash, difficulty))
 }
 
 func (r *Room) Close() {
-	logger.Info("closing room", slog.String("id", r.UUID))
+	r.Logger.Info("closing room")
 
 	for c := range r.Clients {
 		r.CloseClient(c)
@@ -414,23 +922,28 @@ func (r *Room) Close() {
 
 // --- //
 
-func (r *Room) NewClient(c *websocket.Conn, name string) *Client {
+func (r *Room) NewClient(c *websocket.Conn, name string, spectator bool) *Client {
 	client := &Client{
-		Connection: c,
-		Username:   name,
-		Room:       r,
-		Send:       make(chan []byte, 256),
-		UUID:       uuid.NewString(),
-		Closed:     false,
-		State:      CLIENT_IDLE,
+		Connection:  c,
+		Username:    name,
+		Room:        r,
+		Send:        make(chan []byte, 256),
+		UUID:        uuid.NewString(),
+		ResumeToken: uuid.NewString(),
+		State:       CLIENT_IDLE,
+		Spectator:   spectator,
+
+		detached: make(chan struct{}),
 	}
-
-	if len(r.Clients) == 0 {
-		client.Host = true
+	client.Logger = r.Logger.With(slog.String("client_id", client.UUID), slog.String("username", client.Username))
+
+	// The room might have closed while the client was connecting
+	select {
+	case r.Join <- client:
+		return client
+	case <-r.Quit:
+		return nil
 	}
-
-	r.Join <- client
-	return client
 }
 func (r *Room) CloseClient(c *Client) {
 	c.Close()
@@ -1,32 +1,76 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
 )
 
 var Port int = 8080
 var Verbose = false
-var upgrader = websocket.Upgrader{}
+var upgrader = websocket.Upgrader{
+	Subprotocols: supportedSubprotocols(),
+}
 var Version = false
+var ShutdownGracePeriod = time.Second * 60
+var HistoryPath = "history.db"
 
-var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+var logLevel = new(slog.LevelVar)
+var logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+var logCloser io.Closer
 
 var BuildVersion = "0.0.0-dev"
 var BuildCommit = "dev"
 
-func init() {
+// The oldest protocol version that the server still understands
+var MinProtocolVersion = protocol.ProtocolVersion
+
+// Sent to clients in the hello event, so they know what they can use
+var ServerFeatures = []string{
+	"spectate",
+	"resume",
+	"history",
+	"moderation",
+	"host_transfer",
+	"chat",
+	"clock_sync",
+	"latency",
+	"settings",
+	"queue",
+	"voting",
+}
+
+// Reads the flags and the config file. Kept out of init so that tests can run without them.
+func setup() {
 	flag.IntVar(&Port, "port", 8080, "Sets the server port")
 	flag.BoolVar(&Verbose, "verbose", false, "Enable debug messages")
 	flag.BoolVar(&Version, "version", false, "Display version info")
+	flag.StringVar(&HistoryPath, "history", "history.db", "Where to store the match history, empty to disable")
+	flag.StringVar(&AccountsPath, "accounts", "accounts.db", "Where to store registered players, empty to disable accounts")
+	flag.DurationVar(&ShutdownGracePeriod, "shutdown-grace", time.Second*60, "How long to wait for ongoing matches when shutting down")
+	flag.StringVar(&ConfigPath, "config", "config.ini", "Path to the config file")
+	flag.StringVar(&LogFormat, "log-format", "text", "Log format, either text or json")
+	flag.StringVar(&LogFile, "log-file", "", "Where to write the logs, empty for stdout")
+	flag.StringVar(&TLSCertPath, "tls-cert", "", "Path to the TLS certificate, serves over HTTPS along with tls-key")
+	flag.StringVar(&TLSKeyPath, "tls-key", "", "Path to the TLS private key")
+	flag.IntVar(&TLSRedirectPort, "tls-redirect-port", 0, "Port that redirects plain HTTP to HTTPS, 0 to disable")
 
 	flag.Parse()
 
@@ -35,38 +79,247 @@ func init() {
 		os.Exit(0)
 	}
 
-	if Verbose {
-		slogOptions := &slog.HandlerOptions{}
-		slogLevel := new(slog.LevelVar)
-		if Verbose {
-			slogLevel.Set(slog.LevelDebug)
-		} else {
-			slogLevel.Set(slog.LevelInfo)
+	// The default config file is optional, but one that was asked for has to exist
+	configRequired := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configRequired = true
 		}
-		slogOptions.Level = slogLevel
+	})
+
+	cfg, err := LoadConfig(ConfigPath, configRequired)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+	cfg.Apply(false)
+
+	logger, logCloser, err = NewLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not set up logging: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, 200, v)
+}
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Error("marshal error:", slog.Any("error", err))
 
-		logger = slog.New(slog.NewTextHandler(os.Stdout, slogOptions))
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "internal error")
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Errors that clients are expected to act on, rather than just show
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+type ProtocolError struct {
+	APIError
+	Protocol    int `json:"protocol"`
+	MinProtocol int `json:"min_protocol"`
+}
+
+func supportedSubprotocols() []string {
+	protocols := make([]string, 0)
+	for v := protocol.ProtocolVersion; v >= MinProtocolVersion; v-- {
+		protocols = append(protocols, protocol.ProtocolSubprotocolPrefix+strconv.Itoa(v))
+	}
+	return protocols
+}
+
+// Reads the protocol version that the client speaks, either from the query or from the websocket subprotocol
+func requestedProtocol(r *http.Request) (int, bool) {
+	if v := r.URL.Query().Get("protocol"); v != "" {
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+
+	for _, p := range websocket.Subprotocols(r) {
+		if v, ok := strings.CutPrefix(p, protocol.ProtocolSubprotocolPrefix); ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Reads the optional limit query, capped to a sane amount
+func parseHistoryLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return 50
+	}
+
+	return min(limit, 500)
 }
 
 func main() {
+	setup()
+
 	logger.Info("initializing party...")
 
 	lobby := NewLobby()
 
+	if HistoryPath != "" {
+		history, err := OpenHistory(HistoryPath)
+		if err != nil {
+			logger.Error("could not open match history", slog.String("path", HistoryPath), slog.Any("err", err))
+			os.Exit(1)
+		}
+		defer history.Close()
+
+		lobby.History = history
+	}
+
+	if AccountsPath != "" {
+		accounts, err := OpenAccounts(AccountsPath)
+		if err != nil {
+			logger.Error("could not open accounts", slog.String("path", AccountsPath), slog.Any("err", err))
+			os.Exit(1)
+		}
+		defer accounts.Close()
+
+		lobby.Accounts = accounts
+		ServerFeatures = append(ServerFeatures, "accounts")
+	}
+
 	http.HandleFunc("/room/join", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.WriteHeader(400)
 			fmt.Fprintf(w, "unknown method")
 			return
 		}
+		if !allowRequest(joinLimiter, w, r) {
+			return
+		}
+
+		version, ok := requestedProtocol(r)
+		if !ok || version < MinProtocolVersion || version > protocol.ProtocolVersion {
+			message := "missing protocol version"
+			if ok {
+				message = fmt.Sprintf("unsupported protocol version %d", version)
+			}
+
+			writeJSONStatus(w, http.StatusUpgradeRequired, ProtocolError{
+				APIError:    APIError{Code: "protocol_mismatch", Message: message},
+				Protocol:    protocol.ProtocolVersion,
+				MinProtocol: MinProtocolVersion,
+			})
+			return
+		}
 
 		q, _ := url.ParseQuery(r.URL.RawQuery)
 
+		roomID := strings.TrimSpace(q.Get("room"))
+		if roomID == "" {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "missing room")
+			return
+		}
+		room := lobby.GetRoom(roomID)
+		if room == nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown room")
+			return
+		}
+
+		// The client has lost its connection, and wants to reattach itself to the room
+		if token := strings.TrimSpace(q.Get("resume")); token != "" {
+			known := false
+			room.Exec(func() { known = room.GetClientFromResumeToken(token) != nil })
+			if !known {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, "unknown session")
+				return
+			}
+
+			c, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				metrics.UpgradeFailures.Add(1)
+				room.Logger.Error("error in upgrading connection", slog.Any("err", err))
+				return
+			}
+
+			// The room looks up the session again, as the client might have left in the meantime
+			select {
+			case room.Resume <- &ClientResume{token, c}:
+			case <-room.Quit:
+				c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "room has closed"))
+				c.Close()
+			}
+			return
+		}
+
+		if lobby.IsShuttingDown() {
+			w.WriteHeader(503)
+			fmt.Fprintf(w, "server is shutting down")
+			return
+		}
+
 		username := strings.TrimSpace(q.Get("username"))
-		if username == "" {
+		if token := strings.TrimSpace(q.Get("token")); token != "" {
+			if lobby.Accounts == nil {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, "accounts are disabled on this server")
+				return
+			}
+
+			session, err := lobby.Accounts.Session(token)
+			if err != nil {
+				logger.Error("accounts error:", slog.Any("error", err))
+
+				w.WriteHeader(500)
+				fmt.Fprintf(w, "internal error")
+				return
+			}
+			if session == nil {
+				w.WriteHeader(401)
+				fmt.Fprintf(w, "your login has expired, please log in again")
+				return
+			}
+			if username != "" && NormalizeName(username) != NormalizeName(session.Username) {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, "username does not match your account")
+				return
+			}
+
+			username = session.Username
+		} else if lobby.Accounts != nil {
+			if CurrentConfig().Accounts.RequireLogin {
+				w.WriteHeader(401)
+				fmt.Fprintf(w, "you need to log in to join rooms")
+				return
+			}
+
+			registered, err := lobby.Accounts.Exists(username)
+			if err != nil {
+				logger.Error("accounts error:", slog.Any("error", err))
+
+				w.WriteHeader(500)
+				fmt.Fprintf(w, "internal error")
+				return
+			}
+			if registered {
+				w.WriteHeader(403)
+				fmt.Fprintf(w, "username is registered, please log in to use it")
+				return
+			}
+		}
+		if err := ValidateUsername(username); err != nil {
 			w.WriteHeader(400)
-			fmt.Fprintf(w, "missing username")
+			fmt.Fprintf(w, "%s", err)
 			return
 		}
 		if lobby.UsernameExists(username) {
@@ -74,27 +327,46 @@ func main() {
 			fmt.Fprintf(w, "username already exists")
 			return
 		}
+		spectate, _ := strconv.ParseBool(q.Get("spectate"))
 
-		roomID := strings.TrimSpace(q.Get("room"))
-		if roomID == "" {
+		banned, full := false, false
+		if !room.Exec(func() {
+			banned = room.IsBanned(username)
+			full = !spectate && room.IsFull()
+		}) {
 			w.WriteHeader(400)
-			fmt.Fprintf(w, "missing room")
+			fmt.Fprintf(w, "unknown room")
 			return
 		}
-		room := lobby.GetRoom(roomID)
-		if room == nil {
-			w.WriteHeader(400)
-			fmt.Fprintf(w, "unknown room")
+		if banned {
+			w.WriteHeader(403)
+			fmt.Fprintf(w, "you are banned from this room")
+			return
+		}
+		if !room.CheckPassword(q.Get("password")) {
+			w.WriteHeader(403)
+			fmt.Fprintf(w, "incorrect password")
+			return
+		}
+		if full {
+			w.WriteHeader(403)
+			fmt.Fprintf(w, "room is full")
 			return
 		}
 
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			logger.Error("error in upgrading connection", slog.Any("err", err))
+			metrics.UpgradeFailures.Add(1)
+			room.Logger.Error("error in upgrading connection", slog.Any("err", err))
 			return
 		}
 
-		cl := room.NewClient(c, username)
+		cl := room.NewClient(c, username, spectate)
+		if cl == nil {
+			c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "room has closed"))
+			c.Close()
+			return
+		}
 		go cl.Write()
 		go cl.Read()
 	})
@@ -104,8 +376,52 @@ func main() {
 			fmt.Fprintf(w, "unknown method")
 			return
 		}
+		if !allowRequest(createLimiter, w, r) {
+			return
+		}
+
+		if lobby.IsShuttingDown() {
+			w.WriteHeader(503)
+			fmt.Fprintf(w, "server is shutting down")
+			return
+		}
+		cfg := CurrentConfig()
+		if cfg.Limits.MaxRooms > 0 && lobby.GetRoomCount() >= cfg.Limits.MaxRooms {
+			writeJSONStatus(w, http.StatusTooManyRequests, APIError{Code: "too_many_rooms", Message: "the server has reached its room limit, try again later"})
+			return
+		}
+
+		q, _ := url.ParseQuery(r.URL.RawQuery)
+
+		password := q.Get("password")
+		private, _ := strconv.ParseBool(q.Get("private"))
+
+		maxPlayers := cfg.Room.DefaultMaxPlayers
+		if v := q.Get("max_players"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, "invalid max players")
+				return
+			}
+			maxPlayers = n
+		}
+		if cfg.Limits.MaxPlayers > 0 && (maxPlayers == 0 || maxPlayers > cfg.Limits.MaxPlayers) {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "max players must be between 1 and %d", cfg.Limits.MaxPlayers)
+			return
+		}
+
+		title := strings.TrimSpace(q.Get("title"))
+		if title != "" {
+			if err := ValidateRoomTitle(title); err != nil {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, "%s", err)
+				return
+			}
+		}
 
-		room := lobby.NewRoom()
+		room := lobby.NewRoom(title, password, private, maxPlayers)
 
 		data, err := json.Marshal(struct {
 			ID string
@@ -124,6 +440,343 @@ func main() {
 		w.Write(data)
 	})
 
+	http.HandleFunc("/auth/register", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+		if lobby.Accounts == nil {
+			writeJSONStatus(w, 404, APIError{Code: "accounts_disabled", Message: "accounts are disabled on this server"})
+			return
+		}
+		if !allowRequest(authLimiter, w, r) {
+			return
+		}
+
+		username := r.PostFormValue("username")
+		password := r.PostFormValue("password")
+		if err := ValidateUsername(username); err != nil {
+			writeJSONStatus(w, 400, APIError{Code: "invalid_username", Message: err.Error()})
+			return
+		}
+		if err := ValidatePassword(password); err != nil {
+			writeJSONStatus(w, 400, APIError{Code: "invalid_password", Message: err.Error()})
+			return
+		}
+
+		err := lobby.Accounts.Register(username, password)
+		if errors.Is(err, ErrUsernameTaken) {
+			writeJSONStatus(w, 409, APIError{Code: "username_taken", Message: err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error("accounts error:", slog.Any("error", err))
+
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "internal error")
+			return
+		}
+
+		logger.Info("new account registered", slog.String("username", username))
+		writeJSONStatus(w, 201, struct {
+			Username string `json:"username"`
+		}{username})
+	})
+	http.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+		if lobby.Accounts == nil {
+			writeJSONStatus(w, 404, APIError{Code: "accounts_disabled", Message: "accounts are disabled on this server"})
+			return
+		}
+		if !allowRequest(authLimiter, w, r) {
+			return
+		}
+
+		token, session, err := lobby.Accounts.Login(r.PostFormValue("username"), r.PostFormValue("password"))
+		if errors.Is(err, ErrInvalidCredentials) {
+			writeJSONStatus(w, 401, APIError{Code: "invalid_credentials", Message: err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error("accounts error:", slog.Any("error", err))
+
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "internal error")
+			return
+		}
+
+		writeJSON(w, struct {
+			Token     string `json:"token"`
+			Username  string `json:"username"`
+			ExpiresAt int64  `json:"expires_at"`
+		}{token, session.Username, session.ExpiresAt})
+	})
+	http.HandleFunc("/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+		if lobby.Accounts == nil {
+			writeJSONStatus(w, 404, APIError{Code: "accounts_disabled", Message: "accounts are disabled on this server"})
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			writeJSONStatus(w, 401, APIError{Code: "unauthorized", Message: "missing session token"})
+			return
+		}
+
+		if err := lobby.Accounts.Logout(strings.TrimSpace(token)); err != nil {
+			logger.Error("accounts error:", slog.Any("error", err))
+
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "internal error")
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+
+	http.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+		if lobby.History == nil {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "match history is disabled")
+			return
+		}
+
+		matches, err := lobby.History.List(parseHistoryLimit(r))
+		if err != nil {
+			logger.Error("history error:", slog.Any("error", err))
+
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "internal error")
+			return
+		}
+
+		writeJSON(w, matches)
+	})
+	http.HandleFunc("/history/{matchID}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+		if lobby.History == nil {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "match history is disabled")
+			return
+		}
+
+		match, err := lobby.History.Get(r.PathValue("matchID"))
+		if err != nil {
+			logger.Error("history error:", slog.Any("error", err))
+
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "internal error")
+			return
+		}
+		if match == nil {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "unknown match")
+			return
+		}
+
+		writeJSON(w, match)
+	})
+	http.HandleFunc("/rooms/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+		if lobby.History == nil {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "match history is disabled")
+			return
+		}
+
+		matches, err := lobby.History.ListRoom(r.PathValue("id"), parseHistoryLimit(r))
+		if err != nil {
+			logger.Error("history error:", slog.Any("error", err))
+
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "internal error")
+			return
+		}
+
+		writeJSON(w, matches)
+	})
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.Write(w, lobby)
+	})
+
+	http.HandleFunc("/admin/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+
+		room := adminRoom(lobby, w, r)
+		if room == nil {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			var detail AdminRoomDetail
+			if !room.Exec(func() { detail = room.AdminDetail() }) {
+				writeJSONStatus(w, 404, APIError{Code: "unknown_room", Message: "unknown room"})
+				return
+			}
+
+			writeJSON(w, detail)
+			return
+		}
+
+		closed := room.Exec(func() {
+			room.Logger.Info("room closed by an admin")
+
+			room.BroadcastAll(protocol.NewKickedEvent("The room has been closed by an admin", false))
+			lobby.CloseRoom(room.UUID)
+		})
+		if !closed {
+			writeJSONStatus(w, 404, APIError{Code: "unknown_room", Message: "unknown room"})
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+	http.HandleFunc("/admin/rooms/{id}/kick", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+
+		room := adminRoom(lobby, w, r)
+		if room == nil {
+			return
+		}
+
+		q, _ := url.ParseQuery(r.URL.RawQuery)
+
+		clientID := strings.TrimSpace(q.Get("id"))
+		if clientID == "" {
+			writeJSONStatus(w, 400, APIError{Code: "missing_user", Message: "missing user id"})
+			return
+		}
+		reason := q.Get("reason")
+		ban, _ := strconv.ParseBool(q.Get("ban"))
+
+		found := false
+		ok := room.Exec(func() {
+			c := room.GetClientFromID(clientID)
+			if c == nil {
+				return
+			}
+			found = true
+
+			c.Logger.Info("user kicked by an admin")
+			room.KickClient(c, reason, ban)
+		})
+		if !ok {
+			writeJSONStatus(w, 404, APIError{Code: "unknown_room", Message: "unknown room"})
+			return
+		}
+		if !found {
+			writeJSONStatus(w, 404, APIError{Code: "unknown_user", Message: "unknown user"})
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+	http.HandleFunc("/admin/rooms/{id}/force-start", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+
+		room := adminRoom(lobby, w, r)
+		if room == nil {
+			return
+		}
+
+		started := false
+		ok := room.Exec(func() {
+			if !room.IsPreparing() {
+				return
+			}
+
+			room.Logger.Info("match force started by an admin")
+			room.StartMatch(true)
+			started = true
+		})
+		if !ok {
+			writeJSONStatus(w, 404, APIError{Code: "unknown_room", Message: "unknown room"})
+			return
+		}
+		if !started {
+			writeJSONStatus(w, 409, APIError{Code: "invalid_state", Message: "room is not preparing a match"})
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+	http.HandleFunc("/admin/rooms/{id}/force-finish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "unknown method")
+			return
+		}
+
+		room := adminRoom(lobby, w, r)
+		if room == nil {
+			return
+		}
+
+		finished := false
+		ok := room.Exec(func() {
+			if room.IsIdle() {
+				return
+			}
+
+			room.Logger.Info("match force finished by an admin")
+			room.FinishMatch(true)
+			finished = true
+		})
+		if !ok {
+			writeJSONStatus(w, 404, APIError{Code: "unknown_room", Message: "unknown room"})
+			return
+		}
+		if !finished {
+			writeJSONStatus(w, 409, APIError{Code: "invalid_state", Message: "room is not in a match"})
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.WriteHeader(400)
@@ -146,9 +799,98 @@ func main() {
 		w.Write(data)
 	})
 
-	logger.Info("ready to party!")
-	err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", Port), nil)
-	if err != nil {
-		logger.Error("http:", slog.Any("err", err))
+	srv := &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", Port)}
+
+	var certs *CertReloader
+	var redirect *http.Server
+	if TLSEnabled() {
+		var err error
+		certs, err = NewCertReloader(TLSCertPath, TLSKeyPath)
+		if err != nil {
+			logger.Error("could not load certificate", slog.Any("err", err))
+			os.Exit(1)
+		}
+
+		srv.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		if TLSRedirectPort != 0 {
+			redirect = &http.Server{
+				Addr:    fmt.Sprintf("0.0.0.0:%d", TLSRedirectPort),
+				Handler: http.HandlerFunc(redirectToHTTPS),
+			}
+
+			go func() {
+				logger.Info("redirecting http to https", slog.Int("port", TLSRedirectPort))
+				err := redirect.ListenAndServe()
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Error("http redirect:", slog.Any("err", err))
+					os.Exit(1)
+				}
+			}()
+		}
+	}
+
+	go func() {
+		logger.Info("ready to party!", slog.Bool("tls", certs != nil))
+
+		var err error
+		if certs != nil {
+			// The certificate is provided by the TLS config
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http:", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			ReloadConfig()
+
+			if certs != nil {
+				if err := certs.Reload(); err != nil {
+					logger.Error("could not reload certificate, keeping the current one", slog.Any("err", err))
+				} else {
+					logger.Info("certificate has been reloaded")
+				}
+			}
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	logger.Info("received signal, shutting down...", slog.String("signal", sig.String()))
+
+	go func() {
+		<-signals
+		logger.Warn("received another signal, exiting immediately")
+		os.Exit(1)
+	}()
+
+	lobby.Shutdown("The server is shutting down", time.Now().Add(CurrentConfig().Server.ShutdownGrace))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("http shutdown:", slog.Any("err", err))
+	}
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+
+	logger.Info("party's over!")
+
+	if logCloser != nil {
+		logCloser.Close()
 	}
 }
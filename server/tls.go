@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Serves over HTTPS when both are set
+var TLSCertPath = ""
+var TLSKeyPath = ""
+
+// Port of the listener that redirects plain HTTP to HTTPS, 0 if disabled
+var TLSRedirectPort = 0
+
+func TLSEnabled() bool {
+	return TLSCertPath != "" && TLSKeyPath != ""
+}
+
+// Holds the server's certificate, so that renewed certificates can be picked up without a restart
+type CertReloader struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	certPath string
+	keyPath  string
+}
+
+func NewCertReloader(certPath string, keyPath string) (*CertReloader, error) {
+	c := &CertReloader{certPath: certPath, keyPath: keyPath}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reads the certificate again. If it fails, the current certificate is kept.
+func (c *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return fmt.Errorf("certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// Sends plain HTTP requests to the same path on the HTTPS port
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(Port))
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	logger.Debug("redirecting to https", slog.String("target", target))
+
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
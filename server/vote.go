@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"git.jaezmien.com/Jaezmien/notitg-party/protocol"
+)
+
+type VoteState int
+
+// How long players have to nominate songs, and to vote on them
+var RoomNominationDuration = time.Second * 30
+var RoomVoteDuration = time.Second * 20
+
+const (
+	VOTE_IDLE VoteState = iota
+	VOTE_NOMINATING
+	VOTE_VOTING
+)
+
+// Picks the next song of a room in voting mode
+type SongVote struct {
+	State VoteState
+
+	// When the current phase ends
+	EndsAt int64
+
+	// In the order they were nominated, which is what ties are broken by
+	Nominations []protocol.Nomination
+
+	// The nomination each player has voted for, keyed by their id
+	Votes map[string]string
+
+	// Lets the phase timers know if the vote they were started for is still going
+	round int
+}
+
+func (r *Room) IsVoting() bool {
+	return r.Vote.State != VOTE_IDLE
+}
+
+// Returns the vote as sent to the clients, with the running tally of each nomination
+func (r *Room) VoteStatus(winner string) protocol.Vote {
+	nominations := make([]protocol.Nomination, len(r.Vote.Nominations))
+	for i, n := range r.Vote.Nominations {
+		n.Votes = 0
+		for _, id := range r.Vote.Votes {
+			if id == n.ID {
+				n.Votes++
+			}
+		}
+		nominations[i] = n
+	}
+
+	return protocol.Vote{
+		BaseState:   protocol.BaseState{State: int(r.Vote.State)},
+		EndsAt:      r.Vote.EndsAt,
+		Nominations: nominations,
+		Winner:      winner,
+	}
+}
+
+func (r *Room) BroadcastVote(winner string) {
+	r.BroadcastAll(protocol.NewRoomVoteEvent(r.VoteStatus(winner)))
+}
+
+// Throws away the current vote, if any
+func (r *Room) ResetVote() {
+	r.Vote = SongVote{
+		State:       VOTE_IDLE,
+		Nominations: make([]protocol.Nomination, 0),
+		Votes:       make(map[string]string),
+		round:       r.Vote.round + 1,
+	}
+}
+
+// Moves on to the next phase once the current one runs out of time
+func (r *Room) scheduleVotePhase(duration time.Duration) {
+	round, state := r.Vote.round, r.Vote.State
+	r.Vote.EndsAt = time.Now().Add(duration).UnixMilli()
+
+	time.AfterFunc(duration, func() {
+		r.Exec(func() {
+			// The phase might have already ended early, and the next one has a timer of its own
+			if r.Vote.round != round || r.Vote.State != state {
+				return
+			}
+
+			switch state {
+			case VOTE_NOMINATING:
+				r.EndNominations()
+			case VOTE_VOTING:
+				r.FinishVote()
+			}
+		})
+	})
+}
+
+// Checks if every player in the room has done what the current phase asks of them
+func (r *Room) everyPlayerHas(done func(c *Client) bool) bool {
+	players := 0
+	for cli := range r.Clients {
+		if cli.Spectator {
+			continue
+		}
+
+		players++
+		if !done(cli) {
+			return false
+		}
+	}
+
+	return players > 0
+}
+
+func (r *Room) hasNominated(c *Client) bool {
+	return slices.ContainsFunc(r.Vote.Nominations, func(n protocol.Nomination) bool {
+		return n.NominatedBy.ID == c.UUID
+	})
+}
+
+func (r *Room) hasVoted(c *Client) bool {
+	_, ok := r.Vote.Votes[c.UUID]
+	return ok
+}
+
+// Nominates the song on behalf of the player, replacing their previous nomination.
+// The first nomination starts the nomination phase.
+func (r *Room) Nominate(c *Client, hash string, difficulty string) error {
+	if !r.Voting {
+		return errors.New("room is not in voting mode")
+	}
+	if !r.IsIdle() {
+		return errors.New("can't nominate songs during a match")
+	}
+	if c.Spectator {
+		return errors.New("spectators can't nominate songs")
+	}
+	if r.Vote.State == VOTE_VOTING {
+		return errors.New("voting has already started")
+	}
+	if !r.AllowsDifficulty(difficulty) {
+		return errors.New("difficulty is outside of the room's range")
+	}
+
+	if r.Vote.State == VOTE_IDLE {
+		r.ResetVote()
+		r.Vote.State = VOTE_NOMINATING
+		r.scheduleVotePhase(CurrentConfig().Room.NominationTime)
+		r.Logger.Info("room has started taking nominations")
+	}
+
+	song := protocol.SetSong{Hash: hash, Difficulty: difficulty}
+	i := slices.IndexFunc(r.Vote.Nominations, func(n protocol.Nomination) bool {
+		return n.NominatedBy.ID == c.UUID
+	})
+	if i >= 0 {
+		r.Vote.Nominations[i].SetSong = song
+	} else {
+		r.Vote.Nominations = append(r.Vote.Nominations, protocol.Nomination{
+			BaseID:      protocol.BaseID{ID: uuid.NewString()},
+			SetSong:     song,
+			NominatedBy: protocol.User{BaseID: protocol.BaseID{ID: c.UUID}, Username: c.Username},
+		})
+	}
+	c.Logger.Info("song has been nominated")
+
+	if r.everyPlayerHas(r.hasNominated) {
+		r.EndNominations()
+		return nil
+	}
+
+	r.BroadcastVote("")
+	return nil
+}
+
+// Closes the nominations, and opens the vote if there's more than one song to pick from
+func (r *Room) EndNominations() {
+	switch len(r.Vote.Nominations) {
+	case 0:
+		r.ResetVote()
+		r.BroadcastVote("")
+	case 1:
+		r.FinishVote()
+	default:
+		r.Vote.State = VOTE_VOTING
+		r.scheduleVotePhase(CurrentConfig().Room.VoteTime)
+		r.Logger.Info("room has started voting")
+		r.BroadcastVote("")
+	}
+}
+
+// Votes for the nomination on behalf of the player, replacing their previous vote
+func (r *Room) CastVote(c *Client, id string) error {
+	if r.Vote.State != VOTE_VOTING {
+		return errors.New("room is not voting")
+	}
+	if c.Spectator {
+		return errors.New("spectators can't vote")
+	}
+	if !slices.ContainsFunc(r.Vote.Nominations, func(n protocol.Nomination) bool { return n.ID == id }) {
+		return errors.New("unknown nomination")
+	}
+
+	r.Vote.Votes[c.UUID] = id
+
+	if r.everyPlayerHas(r.hasVoted) {
+		r.FinishVote()
+		return nil
+	}
+
+	r.BroadcastVote("")
+	return nil
+}
+
+// Returns the nomination with the most votes. Ties go to whichever was nominated first.
+func (r *Room) VoteWinner() *protocol.Nomination {
+	var winner *protocol.Nomination
+
+	status := r.VoteStatus("")
+	for i, n := range status.Nominations {
+		if winner == nil || n.Votes > winner.Votes {
+			winner = &status.Nominations[i]
+		}
+	}
+
+	return winner
+}
+
+// Loads the winning song, and ends the vote
+func (r *Room) FinishVote() {
+	winner := r.VoteWinner()
+	if winner == nil {
+		r.ResetVote()
+		r.BroadcastVote("")
+		return
+	}
+
+	r.Logger.Info("room has picked a song by vote", slog.Int("votes", winner.Votes))
+
+	// Let everyone see the final tally before it's thrown away
+	r.Vote.State = VOTE_IDLE
+	r.Vote.EndsAt = 0
+	r.BroadcastVote(winner.ID)
+
+	r.ResetVote()
+	r.SetSong(winner.Hash, winner.Difficulty)
+}
+
+// Takes the player out of the ongoing vote
+func (r *Room) RemoveVoter(c *Client) {
+	if !r.IsVoting() {
+		return
+	}
+
+	delete(r.Vote.Votes, c.UUID)
+
+	switch r.Vote.State {
+	case VOTE_NOMINATING:
+		r.Vote.Nominations = slices.DeleteFunc(r.Vote.Nominations, func(n protocol.Nomination) bool {
+			return n.NominatedBy.ID == c.UUID
+		})
+
+		if r.everyPlayerHas(r.hasNominated) {
+			r.EndNominations()
+			return
+		}
+	case VOTE_VOTING:
+		if r.everyPlayerHas(r.hasVoted) {
+			r.FinishVote()
+			return
+		}
+	}
+
+	r.BroadcastVote("")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Creates a voting room with the given number of players, closing it once the test is done
+func newVoteRoom(t *testing.T, players int) (*Room, []*Client) {
+	t.Helper()
+
+	l := NewLobby()
+	r := l.NewRoom("vote", "", false, players)
+	t.Cleanup(func() { r.Exec(func() { l.CloseRoom(r.UUID) }) })
+
+	clients := make([]*Client, players)
+	r.Exec(func() {
+		r.Voting = true
+		for i := range clients {
+			c := &Client{
+				UUID:     string(rune('a' + i)),
+				Username: string(rune('a' + i)),
+				Room:     r,
+				Send:     make(chan []byte, 256),
+				Logger:   r.Logger,
+			}
+			r.Clients[c] = true
+			clients[i] = c
+		}
+	})
+
+	return r, clients
+}
+
+func voteState(r *Room) VoteState {
+	var state VoteState
+	r.Exec(func() { state = r.Vote.State })
+	return state
+}
+
+func TestVoteAfterEarlyNominations(t *testing.T) {
+	useConfig(t, func(c *Config) {
+		c.Room.NominationTime = 100 * time.Millisecond
+		c.Room.VoteTime = 400 * time.Millisecond
+	})
+
+	r, clients := newVoteRoom(t, 2)
+
+	// Everyone nominates right away, so the vote starts before the nominations would've run out
+	r.Exec(func() {
+		if err := r.Nominate(clients[0], "first", "hard"); err != nil {
+			t.Errorf("Nominate: %v", err)
+		}
+		if err := r.Nominate(clients[1], "second", "hard"); err != nil {
+			t.Errorf("Nominate: %v", err)
+		}
+	})
+	if state := voteState(r); state != VOTE_VOTING {
+		t.Fatalf("vote state = %d, want %d", state, VOTE_VOTING)
+	}
+
+	// Past the end of the nominations, but not the vote
+	time.Sleep(250 * time.Millisecond)
+	if state := voteState(r); state != VOTE_VOTING {
+		t.Fatalf("vote state = %d after the nominations would've ended, want %d", state, VOTE_VOTING)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if state := voteState(r); state != VOTE_IDLE {
+		t.Fatalf("vote state = %d after the vote has ended, want %d", state, VOTE_IDLE)
+	}
+
+	var song string
+	r.Exec(func() { song = r.SongHash })
+	if song != "first" {
+		t.Fatalf("song = %q, want %q", song, "first")
+	}
+}
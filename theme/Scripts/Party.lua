@@ -24,6 +24,10 @@ PARTY_CMD.score = 0 -- just for tracking purposes
 PARTY_CMD.ROOM_IDLE = 0
 PARTY_CMD.ROOM_PLAYING = 1
 
+PARTY_CMD.VOTE_IDLE = 0
+PARTY_CMD.VOTE_NOMINATING = 1
+PARTY_CMD.VOTE_VOTING = 2
+
 PARTY_CMD.CLIENT_IDLE = 0
 PARTY_CMD.CLIENT_MISSING_SONG = 1
 PARTY_CMD.CLIENT_LOBBY_READY = 2
@@ -44,20 +48,45 @@ PARTY_CMD.difficulties = {
 PARTY_CMD.room = {}
 function PARTY_CMD:ResetRoomData()
 	PARTY_CMD.room.userid = ''
+	PARTY_CMD.room.serverFeatures = {}
 
 	PARTY_CMD.room.id = ''
 	PARTY_CMD.room.title = ''
 	PARTY_CMD.room.hostid = ''
 	PARTY_CMD.room.state = PARTY_CMD.ROOM_IDLE
+	PARTY_CMD.room.maxPlayers = 0
+	PARTY_CMD.room.settings = {
+		title = '',
+		max_players = 0,
+		private = false,
+		auto_start = false,
+		min_difficulty = '',
+		max_difficulty = '',
+		open_queue = false,
+		voting = false,
+	}
+	PARTY_CMD.room.queue = {}
+	PARTY_CMD.room.vote = {
+		state = PARTY_CMD.VOTE_IDLE,
+		ends_at = 0,
+		nominations = {},
+		winner = '',
+	}
 
 	PARTY_CMD.room.users = {}
 	PARTY_CMD.room.playingUsers = {}
+	PARTY_CMD.room.standings = {}
+	PARTY_CMD.room.chat = {}
 
 	PARTY_CMD.room.hasSong = true
 
 	PARTY_CMD.room.difficulty = ''
 end
 
+function PARTY_CMD:ServerSupports(feature)
+	return PARTY_CMD.room.serverFeatures[feature] == true
+end
+
 function PARTY_CMD:IsInRoom()
 	return PARTY_CMD.room.id ~= ''
 end
@@ -84,6 +113,11 @@ function PARTY_CMD:GetRoomHost()
 	return PARTY_CMD:FindUserByID(PARTY_CMD.room.hostid)
 end
 
+function PARTY_CMD:IsUserSpectating()
+	local u = PARTY_CMD:GetOwnUser()
+	return u ~= nil and u.spectator
+end
+
 function PARTY_CMD:IsUserHost()
 	return PARTY_CMD:IsInRoom() and PARTY_CMD.room.userid == PARTY_CMD.room.hostid
 end
@@ -95,14 +129,30 @@ function PARTY_CMD:NewScreen(s)
 	PARTY_ACTOR:GetChild('LoadingText'):hidden(s == 'ScreenPartyGameplay' and 0 or 1)
 end
 
-function PARTY_CMD:CreateRoom()
-	Lemonade:Send(2, { 2, 2 })
+function PARTY_CMD:CreateRoom(password, private, maxPlayers)
+	if password or private or maxPlayers then
+		local data = Lemonade:Encode(json.encode({
+			password = password or '',
+			private = private or false,
+			max_players = maxPlayers or 0,
+		}))
+		table.insert(data, 1, 2) -- {2, data...}
+		table.insert(data, 1, 2) -- {2, 2, data...}
+		Lemonade:Send(2, data)
+	else
+		Lemonade:Send(2, { 2, 2 })
+	end
 
 	PARTY_CMD:ResetRoomData()
 end
 
-function PARTY_CMD:JoinRoom(id)
-	local data = Lemonade:Encode(id)
+function PARTY_CMD:JoinRoom(id, password, spectate)
+	local data
+	if password or spectate then
+		data = Lemonade:Encode(json.encode({ id = id, password = password or '', spectate = spectate or false }))
+	else
+		data = Lemonade:Encode(id)
+	end
 	table.insert(data, 1, 3) -- {3, data...}
 	table.insert(data, 1, 2) -- {2, 3, data...}
 	Lemonade:Send(2, data)
@@ -114,7 +164,8 @@ function PARTY_CMD:RoomAllReady()
 	if table.getn(PARTY_CMD.room.users) == 0 then return false end
 
 	for _, u in ipairs(PARTY_CMD.room.users) do
-		if u.state ~= PARTY_CMD.CLIENT_LOBBY_READY and
+		if not u.spectator and
+			u.state ~= PARTY_CMD.CLIENT_LOBBY_READY and
 			u.state ~= PARTY_CMD.CLIENT_MISSING_SONG then
 			return false
 		end
@@ -123,7 +174,7 @@ function PARTY_CMD:RoomAllReady()
 	-- handle just in case every player in the lobby is missing the song
 	local allMissing = true
 	for _, u in ipairs(PARTY_CMD.room.users) do
-		if u.state ~= PARTY_CMD.CLIENT_MISSING_SONG then
+		if not u.spectator and u.state ~= PARTY_CMD.CLIENT_MISSING_SONG then
 			allMissing = false
 			break
 		end
@@ -151,6 +202,67 @@ function PARTY_CMD:StartRoom()
 	Lemonade:Send(2, { 3, 5 }) -- Let's get started!
 end
 
+function PARTY_CMD:SetMaxPlayers(n)
+	if not PARTY_CMD:IsUserHost() then return end
+
+	Lemonade:Send(2, { 3, 6, n })
+end
+
+-- Changes some of the room's settings, e.g. { title = 'my room', auto_start = true }
+function PARTY_CMD:SetRoomSettings(changes)
+	if not PARTY_CMD:IsUserHost() then return end
+
+	local settings = {}
+	for k, v in pairs(PARTY_CMD.room.settings) do settings[k] = v end
+	for k, v in pairs(changes) do settings[k] = v end
+
+	local data = Lemonade:Encode(json.encode(settings))
+	table.insert(data, 1, 11) -- {11, data...}
+	table.insert(data, 1, 3) -- {3, 11, data...}
+	Lemonade:Send(2, data)
+end
+
+local function sendModeration(code, id, reason)
+	if not PARTY_CMD:IsUserHost() then return end
+
+	local data = Lemonade:Encode(json.encode({ id = id, reason = reason or '' }))
+	table.insert(data, 1, code) -- {code, data...}
+	table.insert(data, 1, 3) -- {3, code, data...}
+	Lemonade:Send(2, data)
+end
+
+function PARTY_CMD:KickUser(id, reason)
+	sendModeration(7, id, reason)
+end
+
+function PARTY_CMD:BanUser(id, reason)
+	sendModeration(8, id, reason)
+end
+
+function PARTY_CMD:TransferHost(id)
+	if not PARTY_CMD:IsUserHost() then return end
+
+	local data = Lemonade:Encode(id)
+	table.insert(data, 1, 9) -- {9, data...}
+	table.insert(data, 1, 3) -- {3, 9, data...}
+	Lemonade:Send(2, data)
+end
+
+PARTY_CMD.CHAT_BACKLOG = 20
+function PARTY_CMD:SendChat(message)
+	if not PARTY_CMD:IsInRoom() then return end
+	if message == '' then return end
+
+	local data = Lemonade:Encode(message)
+	table.insert(data, 1, 10) -- {10, data...}
+	table.insert(data, 1, 3) -- {3, 10, data...}
+	Lemonade:Send(2, data)
+end
+
+function PARTY_CMD:IsRoomFull(room)
+	return room.max_players > 0 and room.player_count >= room.max_players
+end
+
 function PARTY_CMD:IsRoomPlaying()
 	return PARTY_CMD.room.state == PARTY_CMD.ROOM_PLAYING
 end
@@ -177,16 +289,14 @@ function PARTY_CMD:GameplayFinish()
 	Lemonade:Send(2, { 4, 3, score, marvelous, perfect, great, good, boo, miss })
 end
 
-local newSongHush = hush(3)
-function PARTY_CMD:SetNewSong()
-	if newSongHush() then return end
-
+-- The currently selected song and difficulty, as the client expects it
+local function currentSongData()
 	local song = GAMESTATE:GetCurrentSong()
-	if not song then return end
+	if not song then return nil end
 	local _, _, folder, file = string.find(song:GetSongDir(), [[/([^/]+)/([^/]+)/$]])
 	if not folder or not file then
 		error('error in extracting song folder info')
-		return
+		return nil
 	end
 
 	local jsonData = {
@@ -201,12 +311,85 @@ function PARTY_CMD:SetNewSong()
 		jsonData.difficulty = PARTY_CMD.difficulties[diffIndex]
 	end
 
+	return jsonData
+end
+
+local newSongHush = hush(3)
+function PARTY_CMD:SetNewSong()
+	if newSongHush() then return end
+
+	local jsonData = currentSongData()
+	if not jsonData then return end
+
 	local data = Lemonade:Encode(json.encode(jsonData))
 	table.insert(data, 1, 2) -- {2, data...}
 	table.insert(data, 1, 3) -- {3, 2, data...}
 	Lemonade:Send(2, data)
 end
 
+function PARTY_CMD:CanEditQueue()
+	if PARTY_CMD:IsUserHost() then return true end
+	return PARTY_CMD.room.settings.open_queue and not PARTY_CMD:IsUserSpectating()
+end
+
+local queueSongHush = hush(3)
+function PARTY_CMD:QueueSong()
+	if not PARTY_CMD:CanEditQueue() then return end
+	if queueSongHush() then return end
+
+	local jsonData = currentSongData()
+	if not jsonData then return end
+
+	local data = Lemonade:Encode(json.encode(jsonData))
+	table.insert(data, 1, 12) -- {12, data...}
+	table.insert(data, 1, 3) -- {3, 12, data...}
+	Lemonade:Send(2, data)
+end
+
+function PARTY_CMD:RemoveFromQueue(id)
+	if not PARTY_CMD:CanEditQueue() then return end
+
+	local data = Lemonade:Encode(id)
+	table.insert(data, 1, 13) -- {13, data...}
+	table.insert(data, 1, 3) -- {3, 13, data...}
+	Lemonade:Send(2, data)
+end
+
+local nominateHush = hush(3)
+function PARTY_CMD:NominateSong()
+	if not PARTY_CMD.room.settings.voting then return end
+	if PARTY_CMD:IsUserSpectating() then return end
+	if nominateHush() then return end
+
+	local jsonData = currentSongData()
+	if not jsonData then return end
+
+	local data = Lemonade:Encode(json.encode(jsonData))
+	table.insert(data, 1, 15) -- {15, data...}
+	table.insert(data, 1, 3) -- {3, 15, data...}
+	Lemonade:Send(2, data)
+end
+
+function PARTY_CMD:CastVote(id)
+	if PARTY_CMD.room.vote.state ~= PARTY_CMD.VOTE_VOTING then return end
+	if PARTY_CMD:IsUserSpectating() then return end
+
+	local data = Lemonade:Encode(id)
+	table.insert(data, 1, 16) -- {16, data...}
+	table.insert(data, 1, 3) -- {3, 16, data...}
+	Lemonade:Send(2, data)
+end
+
+-- Moves the song to the given position in PARTY_CMD.room.queue
+function PARTY_CMD:MoveInQueue(id, index)
+	if not PARTY_CMD:CanEditQueue() then return end
+
+	local data = Lemonade:Encode(json.encode({ id = id, index = index - 1 }))
+	table.insert(data, 1, 14) -- {14, data...}
+	table.insert(data, 1, 3) -- {3, 14, data...}
+	Lemonade:Send(2, data)
+end
+
 function PARTY_CMD:BroadcastScore(score)
 	Lemonade:Send(2, { 4, 2, score })
 end
@@ -239,6 +422,12 @@ Lemonade:AddListener(2, 'party', function(buffer)
 			Lemonade:Send(2, { 1, 2 })
 			SCREENMAN:SetNewScreen('ScreenTitleMenu')
 			SCREENMAN:SystemMessage('Client has disconnected!')
+		elseif buffer[2] == 3 then
+			-- Scenario: Same as above, but the client has also told us why
+			local rawData = popBuffer(buffer, 2)
+			Lemonade:Send(2, { 1, 2 })
+			SCREENMAN:SetNewScreen('ScreenTitleMenu')
+			SCREENMAN:SystemMessage(Lemonade:Decode(rawData))
 		end
 	end
 
@@ -253,6 +442,28 @@ Lemonade:AddListener(2, 'party', function(buffer)
 			return
 		end
 
+		if jsonData.type == 'server.shutdown' then
+			local seconds = math.max(0, math.floor(jsonData.data.eta / 1000 - os.time()))
+			SCREENMAN:SystemMessage(jsonData.data.reason .. ' (in ' .. seconds .. ' seconds)')
+		end
+		if jsonData.type == 'hello' then
+			PARTY_CMD.room.serverFeatures = {}
+			for _, v in ipairs(jsonData.data.features) do
+				PARTY_CMD.room.serverFeatures[v] = true
+			end
+		end
+		if jsonData.type == 'self.error' then
+			print(string.format('[Party] server rejected %s: %s', jsonData.data.type, jsonData.data.message))
+		end
+		if jsonData.type == 'self.kicked' then
+			local message = jsonData.data.banned and 'You have been banned from the room' or 'You have been kicked from the room'
+			if jsonData.data.reason ~= '' then
+				message = message .. ': ' .. jsonData.data.reason
+			end
+
+			SCREENMAN:SetNewScreen('ScreenPartyLobby')
+			SCREENMAN:SystemMessage(message)
+		end
 		if jsonData.type == 'self.user' then
 			PARTY_CMD.room.userid = jsonData.data.id
 		end
@@ -269,10 +480,43 @@ Lemonade:AddListener(2, 'party', function(buffer)
 			local u = PARTY_CMD:FindUserByID(jsonData.data.id)
 			if u then
 				u.state = jsonData.data.state
+
+				-- Spectators don't receive room.start, so keep track of who's playing here instead
+				if PARTY_CMD:IsUserSpectating() and
+					u.state == PARTY_CMD.CLIENT_GAME_LOADING and
+					not PARTY_CMD:FindPlayingUserByID(u.id) then
+					table.insert(PARTY_CMD.room.playingUsers, {
+						username = u.username,
+						id = u.id,
+						left = false,
+						score = 0,
+						index = table.getn(PARTY_CMD.room.playingUsers) + 1,
+						judgments = nil,
+					})
+				end
 			end
 		end
+		if jsonData.type == 'room.info.capacity' then
+			PARTY_CMD.room.maxPlayers = jsonData.data.max_players
+		end
+		if jsonData.type == 'room.info.vote' then
+			PARTY_CMD.room.vote = jsonData.data
+			PARTY_CMD.room.vote.nominations = jsonData.data.nominations or {}
+		end
+		if jsonData.type == 'room.info.queue' then
+			PARTY_CMD.room.queue = jsonData.data.entries or {}
+		end
+		if jsonData.type == 'room.info.settings' then
+			PARTY_CMD.room.settings = jsonData.data
+			PARTY_CMD.room.title = jsonData.data.title
+			PARTY_CMD.room.maxPlayers = jsonData.data.max_players
+		end
 		if jsonData.type == 'room.state' then
 			PARTY_CMD.room.state = jsonData.data.state
+
+			if PARTY_CMD:IsUserSpectating() and PARTY_CMD.room.state == PARTY_CMD.ROOM_IDLE then
+				PARTY_CMD.room.playingUsers = {}
+			end
 		end
 		if jsonData.type == 'room.info.song' then
 			local hash = jsonData.data.hash
@@ -292,8 +536,40 @@ Lemonade:AddListener(2, 'party', function(buffer)
 				username = jsonData.data.username,
 				id = jsonData.data.id,
 				state = jsonData.data.state,
+				spectator = jsonData.data.spectator,
+				latency = 0,
 			})
 		end
+		if jsonData.type == 'room.chat' then
+			table.insert(PARTY_CMD.room.chat, {
+				id = jsonData.data.id,
+				username = jsonData.data.username,
+				message = jsonData.data.message,
+				timestamp = jsonData.data.timestamp,
+			})
+			if #PARTY_CMD.room.chat > PARTY_CMD.CHAT_BACKLOG then
+				table.remove(PARTY_CMD.room.chat, 1)
+			end
+
+			MESSAGEMAN:Broadcast('PartyChat')
+		end
+		if jsonData.type == 'self.chat.rejected' then
+			SCREENMAN:SystemMessage('Chat: ' .. jsonData.data.reason)
+		end
+		if jsonData.type == 'room.user.latency' then
+			for _, v in ipairs(jsonData.data.users) do
+				local u = PARTY_CMD:FindUserByID(v.id)
+				if u then u.latency = v.latency end
+			end
+		end
+		if jsonData.type == 'room.user.reconnecting' then
+			local u = PARTY_CMD:FindUserByID(jsonData.data.id)
+			if u then u.reconnecting = true end
+		end
+		if jsonData.type == 'room.user.resume' then
+			local u = PARTY_CMD:FindUserByID(jsonData.data.id)
+			if u then u.reconnecting = false end
+		end
 		if jsonData.type == 'room.user.leave' then
 			for i, v in ipairs(PARTY_CMD.room.users) do
 				if v.id == jsonData.data.id then
@@ -363,6 +639,8 @@ Lemonade:AddListener(2, 'party', function(buffer)
 			end
 		end
 		if jsonData.type == 'room.eval.show' then
+			-- The server's final standings, already sorted by rank
+			PARTY_CMD.room.standings = jsonData.data.standings or {}
 			MESSAGEMAN:Broadcast('PartyEvaluationShow')
 		end
 	end
@@ -380,6 +658,11 @@ Lemonade:AddListener(2, 'party', function(buffer)
 			GAMESTATE:SetCurrentSong(nil)
 			SCREENMAN:SetNewScreen('ScreenPartyRoom')
 		end
+		if buffer[2] == 3 then
+			-- We couldn't join the room, the rest of the message is the reason why
+			local rawData = popBuffer(buffer, 2)
+			SCREENMAN:SystemMessage('Could not join room: ' .. Lemonade:Decode(rawData))
+		end
 	end
 
 	-- Room!